@@ -4,14 +4,23 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	// Pretty defines if use pretty print
 	Pretty = true
+
+	// transferred counts bytes through every bar, which every transfer has
+	transferred atomic.Int64
 )
 
+// Transferred returns the bytes moved through all bars so far
+func Transferred() int64 {
+	return transferred.Load()
+}
+
 // Container holds attributes of a bar
 type Container struct {
 	bars        []*ProgressBar
@@ -100,6 +109,7 @@ func (b *Container) New(total int64, prepend string) *ProgressBar {
 
 // IncrBy increate progress by an number
 func (p *ProgressBar) IncrBy(delta int64) {
+	transferred.Add(delta)
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
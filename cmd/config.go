@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/gcs"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/s3"
+	"github.com/nextbillion-ai/gsg/system"
+)
+
+// configEnv names the config file when --config does not
+const configEnv = "GSG_CONFIG"
+
+// configPath is set by --config
+var configPath string
+
+// gsgConfig is the config file. Each section has named profiles, every name
+// also a scheme of its own, and routes buckets of the section's scheme to a
+// profile by name. For example, to reach Cloudflare R2 as r2://, keep one
+// bucket of s3:// on a MinIO server, and reach a partner's requester pays
+// bucket of gs:// with a key of their project:
+//
+//	{
+//	  "s3": {
+//	    "profiles": {
+//	      "r2": {"endpoint": "https://<account>.r2.cloudflarestorage.com", "region": "auto", "checksum_compat": true},
+//	      "minio": {"endpoint": "http://minio.internal:9000", "path_style": true, "access_key_id": "...", "secret_access_key": "..."}
+//	    },
+//	    "buckets": {"build-cache": "minio"}
+//	  },
+//	  "gs": {
+//	    "profiles": {
+//	      "partner": {"credentials_file": "/etc/gsg/partner.json", "billing_project": "my-project"}
+//	    },
+//	    "buckets": {"partner-exports": "partner"}
+//	  }
+//	}
+type gsgConfig struct {
+	S3 struct {
+		// Profiles are ways of reaching S3 or an S3-compatible store, by
+		// name. Each name is a scheme of its own, except s3, which
+		// reconfigures s3:// itself.
+		Profiles map[string]*s3.Profile `json:"profiles"`
+		// Endpoints is what Profiles were called before they could carry
+		// credentials, still read so older files keep working.
+		Endpoints map[string]*s3.Profile `json:"endpoints"`
+		// Buckets sends buckets of s3:// to one of Profiles
+		Buckets map[string]string `json:"buckets"`
+	} `json:"s3"`
+	GS struct {
+		// Profiles are ways of reaching GCS, by name. Each name is a scheme
+		// of its own, except gs, which reconfigures gs:// itself.
+		Profiles map[string]*gcs.Profile `json:"profiles"`
+		// Buckets sends buckets of gs:// to one of Profiles
+		Buckets map[string]string `json:"buckets"`
+	} `json:"gs"`
+}
+
+// defaultConfigPath is where the config file is looked for when neither
+// --config nor the env-var names one.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gsg", "config.json")
+}
+
+// readConfig reads the config file at path. A file that was asked for by name
+// has to exist; the default one does not.
+func readConfig(path string, named bool) (*gsgConfig, error) {
+	conf := &gsgConfig{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !named && os.IsNotExist(err) {
+			return conf, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return conf, nil
+}
+
+// applyConfig registers a backend for every profile, and routes buckets to
+// theirs.
+func applyConfig(conf *gsgConfig) error {
+	profiles := map[string]*s3.Profile{}
+	for name, p := range conf.S3.Endpoints {
+		profiles[name] = p
+	}
+	for name, p := range conf.S3.Profiles {
+		if _, ok := profiles[name]; ok {
+			return fmt.Errorf("profile %s is also configured as an endpoint", name)
+		}
+		profiles[name] = p
+	}
+	defS3 := system.Lookup("s3").(*s3.S3)
+	for name, p := range profiles {
+		if err := checkAlias[*s3.S3](name); err != nil {
+			return err
+		}
+		b := s3.New(name, p)
+		b.Anonymous, b.RequestPayer = defS3.Anonymous, defS3.RequestPayer
+		b.SSEKMSKeyID, b.SSECustomerKey = defS3.SSEKMSKeyID, defS3.SSECustomerKey
+		system.Register(b)
+	}
+	defS3 = system.Lookup("s3").(*s3.S3)
+	for bucket, name := range conf.S3.Buckets {
+		p, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("bucket %s is sent to profile %s, which is not configured", bucket, name)
+		}
+		defS3.SetBucketProfile(bucket, p)
+	}
+
+	defGS := system.Lookup("gs").(*gcs.GCS)
+	for name, p := range conf.GS.Profiles {
+		if err := checkAlias[*gcs.GCS](name); err != nil {
+			return err
+		}
+		g := gcs.New(name, p)
+		// The flags apply to every profile, under what it sets itself.
+		g.Endpoint, g.ImpersonateServiceAccount = defGS.Endpoint, defGS.ImpersonateServiceAccount
+		g.BillingProject, g.Anonymous = defGS.BillingProject, defGS.Anonymous
+		g.KMSKeyName, g.EncryptionKey = defGS.KMSKeyName, defGS.EncryptionKey
+		system.Register(g)
+	}
+	defGS = system.Lookup("gs").(*gcs.GCS)
+	for bucket, name := range conf.GS.Buckets {
+		p, ok := conf.GS.Profiles[name]
+		if !ok {
+			return fmt.Errorf("bucket %s is sent to profile %s, which is not configured", bucket, name)
+		}
+		defGS.SetBucketProfile(bucket, p)
+	}
+	return nil
+}
+
+// checkAlias makes sure registering a backend of type T under name replaces
+// nothing but another of the same kind.
+func checkAlias[T system.ISystem](name string) error {
+	if sys := system.Lookup(name); sys != nil {
+		if _, ok := sys.(T); !ok {
+			return fmt.Errorf("profile %s would replace the %s:// backend", name, name)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads and applies the config file, exiting if it is unusable
+func loadConfig() {
+	path, named := configPath, true
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	if path == "" {
+		path, named = defaultConfigPath(), false
+	}
+	if path == "" {
+		return
+	}
+	conf, err := readConfig(path, named)
+	if err == nil {
+		err = applyConfig(conf)
+	}
+	if err != nil {
+		logger.Info(module, "invalid config: %s", err)
+		common.Exit()
+	}
+}
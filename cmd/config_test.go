@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/gcs"
+	"github.com/nextbillion-ai/gsg/s3"
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	conf, err := readConfig(filepath.Join(dir, "missing.json"), false)
+	assert.Nil(t, err)
+	assert.Empty(t, conf.S3.Endpoints)
+	_, err = readConfig(filepath.Join(dir, "missing.json"), true)
+	assert.NotNil(t, err)
+
+	path := filepath.Join(dir, "config.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"s3": {
+		"endpoints": {"minio": {"endpoint": "http://localhost:9000", "path_style": true}},
+		"buckets": {"cache": "minio"}
+	}}`), 0600))
+	conf, err = readConfig(path, true)
+	assert.Nil(t, err)
+	assert.Equal(t, &s3.Profile{Endpoint: s3.Endpoint{URL: "http://localhost:9000", PathStyle: true}}, conf.S3.Endpoints["minio"])
+	assert.Equal(t, "minio", conf.S3.Buckets["cache"])
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{
+		"s3": {"profiles": {"backup": {"aws_profile": "backup", "region": "eu-west-1", "storage_class": "GLACIER_IR"}}},
+		"gs": {
+			"profiles": {"partner": {"credentials_file": "/etc/gsg/partner.json", "billing_project": "mine"}},
+			"buckets": {"exports": "partner"}
+		}
+	}`), 0600))
+	conf, err = readConfig(path, true)
+	assert.Nil(t, err)
+	assert.Equal(t, &s3.Profile{Endpoint: s3.Endpoint{Region: "eu-west-1"}, AWSProfile: "backup", StorageClass: "GLACIER_IR"}, conf.S3.Profiles["backup"])
+	assert.Equal(t, &gcs.Profile{CredentialsFile: "/etc/gsg/partner.json", BillingProject: "mine"}, conf.GS.Profiles["partner"])
+	assert.Equal(t, "partner", conf.GS.Buckets["exports"])
+}
+
+func TestApplyConfig(t *testing.T) {
+	conf := &gsgConfig{}
+	conf.S3.Profiles = map[string]*s3.Profile{"gsgtest": {Endpoint: s3.Endpoint{URL: "http://localhost:9000"}}}
+	assert.Nil(t, applyConfig(conf))
+	fo := system.ParseFileObject("gsgtest://bucket/key")
+	assert.Equal(t, "gsgtest", fo.System.Scheme())
+	assert.Equal(t, "bucket", fo.Bucket)
+
+	conf.S3.Buckets = map[string]string{"b": "nowhere"}
+	assert.NotNil(t, applyConfig(conf))
+
+	conf = &gsgConfig{}
+	conf.S3.Endpoints = map[string]*s3.Profile{"gs": {Endpoint: s3.Endpoint{URL: "http://localhost:9000"}}}
+	assert.NotNil(t, applyConfig(conf))
+
+	conf = &gsgConfig{}
+	conf.S3.Endpoints = map[string]*s3.Profile{"gsgtest": {}}
+	conf.S3.Profiles = map[string]*s3.Profile{"gsgtest": {}}
+	assert.NotNil(t, applyConfig(conf))
+
+	conf = &gsgConfig{}
+	conf.GS.Profiles = map[string]*gcs.Profile{"gsgtest-gs": {BillingProject: "mine"}}
+	conf.GS.Buckets = map[string]string{"exports": "gsgtest-gs"}
+	assert.Nil(t, applyConfig(conf))
+	assert.Equal(t, "gsgtest-gs", system.ParseFileObject("gsgtest-gs://bucket/key").System.Scheme())
+	conf.GS.Profiles = map[string]*gcs.Profile{"gsgtest": {}}
+	assert.NotNil(t, applyConfig(conf))
+}
@@ -2,19 +2,22 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nextbillion-ai/gsg/common"
 	"github.com/nextbillion-ai/gsg/linux"
 	"github.com/nextbillion-ai/gsg/logger"
 	"github.com/nextbillion-ai/gsg/system"
+	"github.com/nextbillion-ai/gsg/worker"
 
 	"github.com/spf13/cobra"
 )
@@ -22,28 +25,29 @@ import (
 func init() {
 	cpCmd.Flags().BoolP("r", "r", false, "copy an entire directory tree")
 	cpCmd.Flags().BoolP("v", "v", false, "force checksum after command operated, raise error if failed")
+	cpCmd.Flags().BoolP("I", "I", false, "read source urls from stdin, one per line or NUL separated")
+	cpCmd.Flags().String("from-file", "", "read source urls from this file, one per line or NUL separated")
+	cpCmd.Flags().StringArray("to", nil, "copy every source to this destination too, reading each source once; repeatable")
+	cpCmd.Flags().StringP("L", "L", "", "append a record of every transfer to this csv or jsonl log, and skip those it records as done")
+	cpCmd.Flags().String("schedule", scheduleListing, "order of the files of a tree: listing, largest-first, or balanced to also batch tiny files and split large downloads across all workers")
 	rootCmd.AddCommand(cpCmd)
 }
 
-func upload(src, dst *system.FileObject, _, isRec bool, wg *sync.WaitGroup) {
+func upload(src, dst *system.FileObject, _, isRec bool, g *worker.Group) {
 	var err error
 	switch src.FileType() {
 	case system.FileType_Directory:
 		if isRec {
-			var objs []*system.FileObject
-			if objs, err = src.System.List(src.Bucket, src.Prefix, isRec); err != nil {
-				common.Exit()
-			}
-			for _, obj := range objs {
+			if err = walkTransfers(src, isRec, g, func(obj *system.FileObject) transfer {
 				op := obj.Prefix
 				dstPath := common.GetDstPath(linux.GetRealPath(src.Prefix), op, dst.Prefix)
-				wg.Add(1)
-				pool.Add(func() {
-					defer wg.Done()
-					if e := dst.System.Upload(op, dst.Bucket, dstPath, system.RunContext{Bars: bars}); e != nil {
-						common.Exit()
-					}
-				})
+				return transfer{size: objectSize(obj), run: func() error {
+					return logTransfer(src.System, src.Bucket, op, dst.System, dst.Bucket, dstPath, func() error {
+						return dst.System.Upload(op, dst.Bucket, dstPath, system.RunContext{Bars: bars, SHA256: withSHA256, UploadLimit: uploadLimit})
+					})
+				}}
+			}); err != nil {
+				common.Exit()
 			}
 		} else {
 			logger.Info(module, "Omitting prefix[%s]. (Did you mean to do cp -r?)", src.Prefix)
@@ -55,12 +59,10 @@ func upload(src, dst *system.FileObject, _, isRec bool, wg *sync.WaitGroup) {
 			_, name := common.ParseFile(src.Prefix)
 			dstPrefix = common.JoinPath(dstPrefix, name)
 		}
-		wg.Add(1)
-		pool.Add(func() {
-			defer wg.Done()
-			if e := dst.System.Upload(src.Prefix, dst.Bucket, dstPrefix, system.RunContext{Bars: bars}); e != nil {
-				common.Exit()
-			}
+		g.Go(func(context.Context) error {
+			return logTransfer(src.System, src.Bucket, src.Prefix, dst.System, dst.Bucket, dstPrefix, func() error {
+				return dst.System.Upload(src.Prefix, dst.Bucket, dstPrefix, system.RunContext{Bars: bars, SHA256: withSHA256, UploadLimit: uploadLimit})
+			})
 		})
 	case system.FileType_Invalid:
 		logger.Info(module, "Invalid prefix[%s]", src.Prefix)
@@ -68,31 +70,22 @@ func upload(src, dst *system.FileObject, _, isRec bool, wg *sync.WaitGroup) {
 	}
 }
 
-func download(src, dst *system.FileObject, forceChecksum, isRec bool, wg *sync.WaitGroup) {
+func download(src, dst *system.FileObject, forceChecksum, isRec bool, g *worker.Group) {
 	var err error
 	switch src.FileType() {
 	case system.FileType_Directory:
 		if isRec {
-			var objs []*system.FileObject
-			if objs, err = src.System.List(src.Bucket, src.Prefix, isRec); err != nil {
-				common.Exit()
-			}
-			for _, obj := range objs {
+			if err = walkTransfers(src, isRec, g, func(obj *system.FileObject) transfer {
 				dstPath := common.GetDstPath(src.Prefix, obj.Prefix, dst.Prefix)
 				srcPath := obj.Prefix
-				wg.Add(1)
-				pool.Add(func() {
-					defer wg.Done()
-					// A local error, as every sibling here already uses. This
-					// assigned the function-scoped err instead, so all the
-					// download goroutines shared one variable: the statement
-					// writes err and then reads it back for the comparison, and
-					// another goroutine overwriting it in between let a
-					// goroutine miss its own failure and report nothing.
-					if e := src.System.Download(src.Bucket, srcPath, dstPath, forceChecksum, system.RunContext{Bars: bars, Pool: pool, ChunkSize: chunkSize, GentleIO: gentleIO}); e != nil {
-						common.Exit()
-					}
-				})
+				size := objectSize(obj)
+				return transfer{size: size, run: func() error {
+					return logTransfer(src.System, src.Bucket, srcPath, dst.System, dst.Bucket, dstPath, func() error {
+						return src.System.Download(src.Bucket, srcPath, dstPath, forceChecksum, system.RunContext{Bars: bars, Group: g, ChunkSize: chunkSizeFor(size), GentleIO: gentleIO, DownloadLimit: downloadLimit})
+					})
+				}}
+			}); err != nil {
+				common.Exit()
 			}
 		} else {
 			logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do cp -r?)", src.Bucket, src.Prefix)
@@ -104,16 +97,43 @@ func download(src, dst *system.FileObject, forceChecksum, isRec bool, wg *sync.W
 			_, name := common.ParseFile(src.Prefix)
 			dstPrefix = common.JoinPath(dst.Prefix, name)
 		}
-		if err = src.System.Download(src.Bucket, src.Prefix, dstPrefix, forceChecksum, system.RunContext{Bars: bars, Pool: pool, ChunkSize: chunkSize, GentleIO: gentleIO}); err != nil {
-			common.Exit()
-		}
+		// A pool job like every other transfer, so that sources read with -I
+		// download in parallel. Its chunks go to the group's child.
+		g.Go(func(context.Context) error {
+			return logTransfer(src.System, src.Bucket, src.Prefix, dst.System, dst.Bucket, dstPrefix, func() error {
+				return src.System.Download(src.Bucket, src.Prefix, dstPrefix, forceChecksum, system.RunContext{Bars: bars, Group: g, ChunkSize: chunkSizeFor(objectSize(src)), GentleIO: gentleIO, DownloadLimit: downloadLimit})
+			})
+		})
 	case system.FileType_Invalid:
 		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", src.Bucket, src.Prefix)
 		common.Exit()
 	}
 }
 
-func interCloudCopy(src, dst *system.FileObject, forceChecksum, isRec bool, wg *sync.WaitGroup) {
+// relay copies one object between clouds by way of a local intermediate file,
+// as a job of g.
+func relay(g *worker.Group, src *system.FileObject, srcPath, interPath string, size int64, dst *system.FileObject, dstPath string, forceChecksum bool) error {
+	var err error
+	if err = src.System.Download(src.Bucket, srcPath, interPath, forceChecksum, system.RunContext{Bars: bars, Group: g, ChunkSize: chunkSizeFor(size), GentleIO: gentleIO, DownloadLimit: downloadLimit}); err != nil {
+		return err
+	}
+	interFile := system.ParseFileObject(interPath)
+	if interFile.FileType() != system.FileType_Object {
+		logger.Error("inter-cloud", "failed to parse intermediate file: %s to file object", interPath)
+		return fmt.Errorf("failed to parse intermediate file: %s to file object", interPath)
+	}
+	if err = dst.System.Upload(interPath, dst.Bucket, dstPath, system.RunContext{Bars: bars, Group: g, SHA256: withSHA256, UploadLimit: uploadLimit}); err != nil {
+		logger.Error("inter-cloud", "failed to upload intermediate file: %s to %s", interPath, dstPath)
+		return err
+	}
+	if err = os.Remove(interPath); err != nil {
+		logger.Error("inter-cloud", "failed to remove intermediate file: %s", interPath)
+		return err
+	}
+	return nil
+}
+
+func interCloudCopy(src, dst *system.FileObject, forceChecksum, isRec bool, g *worker.Group) {
 	var interChange *system.FileObject
 	prepareWorkDir := func() {
 		var err error
@@ -144,37 +164,27 @@ func interCloudCopy(src, dst *system.FileObject, forceChecksum, isRec bool, wg *
 	case system.FileType_Directory:
 		if isRec {
 			prepareWorkDir()
-			var objs []*system.FileObject
-			if objs, err = src.System.List(src.Bucket, src.Prefix, isRec); err != nil {
-				common.Exit()
-			}
-			for _, obj := range objs {
+			// The work dir can only go once every relay through it is done,
+			// so the tree's transfers are waited on apart from the rest.
+			tree := g.Sibling().FailFast()
+			if err = walkTransfers(src, isRec, tree, func(obj *system.FileObject) transfer {
 				interPath := common.GetDstPath(src.Prefix, obj.Prefix, interChange.Prefix)
 				dstPath := common.GetDstPath(linux.GetRealPath(interChange.Prefix), obj.Prefix, dst.Prefix)
 				srcPath := obj.Prefix
-				wg.Add(1)
-				pool.Add(func() {
-					var err error
-					defer wg.Done()
-					if err = src.System.Download(src.Bucket, srcPath, interPath, forceChecksum, system.RunContext{Bars: bars, Pool: pool, ChunkSize: chunkSize, GentleIO: gentleIO}); err != nil {
-						common.Exit()
-					}
-					interFile := system.ParseFileObject(interPath)
-					if interFile.FileType() != system.FileType_Object {
-						logger.Error("inter-cloud", "failed to parse intermediate file: %s to file object", interPath)
-						common.Exit()
-					}
-					if err = dst.System.Upload(interPath, dst.Bucket, dstPath, system.RunContext{Bars: bars, Pool: pool}); err != nil {
-						logger.Error("inter-cloud", "failed to upload intermediate file: %s to %s", interPath, dstPath)
-						common.Exit()
-					}
-					if err := os.Remove(interPath); err != nil {
-						logger.Error("inter-cloud", "failed to remove intermediate file: %s", interPath)
-						common.Exit()
-					}
-				})
+				size := objectSize(obj)
+				return transfer{size: size, run: func() error {
+					return logTransfer(src.System, src.Bucket, srcPath, dst.System, dst.Bucket, dstPath, func() error {
+						return relay(tree, src, srcPath, interPath, size, dst, dstPath, forceChecksum)
+					})
+				}}
+			}); err != nil {
+				common.Exit()
 			}
-			removeWorkDir()
+			g.Background(func(context.Context) error {
+				err := tree.Wait()
+				removeWorkDir()
+				return err
+			})
 		} else {
 			logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do cp -r?)", src.Bucket, src.Prefix)
 			common.Exit()
@@ -187,32 +197,70 @@ func interCloudCopy(src, dst *system.FileObject, forceChecksum, isRec bool, wg *
 			dstPrefix = common.JoinPath(dst.Prefix, name)
 		}
 		interPath := common.JoinPath(interChange.Prefix, name)
-		if err = src.System.Download(src.Bucket, src.Prefix, interPath, forceChecksum, system.RunContext{Bars: bars, Pool: pool, ChunkSize: chunkSize, GentleIO: gentleIO}); err != nil {
-			common.Exit()
-		}
-		interFile := system.ParseFileObject(interPath)
-		if interFile.FileType() != system.FileType_Object {
-			logger.Error("inter-cloud", "failed to parse intermediate file: %s to file object", interPath)
-			common.Exit()
-		}
-		if err = dst.System.Upload(interPath, dst.Bucket, dstPrefix, system.RunContext{Bars: bars, Pool: pool}); err != nil {
-			logger.Error("inter-cloud", "failed to upload intermediate file: %s to %s", interPath, dstPrefix)
-			common.Exit()
-		}
-		if err := os.Remove(interPath); err != nil {
-			logger.Error("inter-cloud", "failed to remove intermediate file: %s", interPath)
-			common.Exit()
-		}
-		removeWorkDir()
+		g.Go(func(context.Context) error {
+			err := logTransfer(src.System, src.Bucket, src.Prefix, dst.System, dst.Bucket, dstPrefix, func() error {
+				return relay(g, src, src.Prefix, interPath, objectSize(src), dst, dstPrefix, forceChecksum)
+			})
+			removeWorkDir()
+			return err
+		})
 	case system.FileType_Invalid:
 		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", src.Bucket, src.Prefix)
 		common.Exit()
 	}
 }
 
-func cloudCopy(src, dst *system.FileObject, forceCheckum, isRec bool, wg *sync.WaitGroup) {
+// copyObject copies an object within one backend, giving the copy the bars
+// and the group it runs as a job of, g, where the backend can use them.
+func copyObject(g *worker.Group, sys system.ISystem, srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
+	if rc, ok := sys.(system.RunCopier); ok {
+		return rc.CopyWithContext(srcBucket, srcPrefix, dstBucket, dstPrefix, system.RunContext{Bars: bars, Group: g})
+	}
+	return sys.Copy(srcBucket, srcPrefix, dstBucket, dstPrefix)
+}
+
+// noSourceKey is the --source-encryption-key that reads sources in the clear
+const noSourceKey = "none"
+
+// sourceKeyer is implemented by backends that can read a bucket with a key
+// of its own
+type sourceKeyer interface {
+	SourceKey(bucket, key string) error
+}
+
+// keySource has src read with --source-encryption-key, when it is given,
+// rather than the keys the other flags give every bucket: a copy's source
+// may be stored in the clear, or under another key, while its destination
+// is encrypted. A bucket has one key, so a source in a bucket that is also
+// written to cannot have its own.
+func keySource(src *system.FileObject, dsts ...*system.FileObject) {
+	if src == nil || !src.Remote || sourceKey == "" {
+		return
+	}
+	for _, dst := range dsts {
+		if dst != nil && dst.System == src.System && dst.Bucket == src.Bucket {
+			logger.Info(module, "--source-encryption-key cannot apply to bucket[%s], which is written to as well", src.Bucket)
+			common.Exit()
+			return
+		}
+	}
+	k, ok := src.System.(sourceKeyer)
+	if !ok {
+		return
+	}
+	key := sourceKey
+	if key == noSourceKey {
+		key = ""
+	}
+	if err := k.SourceKey(src.Bucket, key); err != nil {
+		logger.Info(module, "invalid source encryption key for bucket[%s]: %s", src.Bucket, err)
+		common.Exit()
+	}
+}
+
+func cloudCopy(src, dst *system.FileObject, forceCheckum, isRec bool, g *worker.Group) {
 	if src.System != dst.System {
-		interCloudCopy(src, dst, forceCheckum, isRec, wg)
+		interCloudCopy(src, dst, forceCheckum, isRec, g)
 		return
 	}
 	var err error
@@ -222,20 +270,16 @@ func cloudCopy(src, dst *system.FileObject, forceCheckum, isRec bool, wg *sync.W
 			logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do cp -r?)", src.Bucket, src.Prefix)
 			common.Exit()
 		}
-		var objs []*system.FileObject
-		if objs, err = src.System.List(src.Bucket, src.Prefix, isRec); err != nil {
-			common.Exit()
-		}
-		for _, obj := range objs {
+		if err = walkTransfers(src, isRec, g, func(obj *system.FileObject) transfer {
 			op := obj.Prefix
 			dstPath := common.GetDstPath(src.Prefix, op, dst.Prefix)
-			wg.Add(1)
-			pool.Add(func() {
-				defer wg.Done()
-				if e := src.System.Copy(src.Bucket, op, dst.Bucket, dstPath); e != nil {
-					common.Exit()
-				}
-			})
+			return transfer{size: objectSize(obj), run: func() error {
+				return logTransfer(src.System, src.Bucket, op, dst.System, dst.Bucket, dstPath, func() error {
+					return copyObject(g, src.System, src.Bucket, op, dst.Bucket, dstPath)
+				})
+			}}
+		}); err != nil {
+			common.Exit()
 		}
 	case system.FileType_Object:
 		dstPrefix := dst.Prefix
@@ -243,12 +287,10 @@ func cloudCopy(src, dst *system.FileObject, forceCheckum, isRec bool, wg *sync.W
 			_, name := common.ParseFile(src.Prefix)
 			dstPrefix = common.JoinPath(dst.Prefix, name)
 		}
-		wg.Add(1)
-		pool.Add(func() {
-			defer wg.Done()
-			if e := src.System.Copy(src.Bucket, src.Prefix, dst.Bucket, dstPrefix); e != nil {
-				common.Exit()
-			}
+		g.Go(func(context.Context) error {
+			return logTransfer(src.System, src.Bucket, src.Prefix, dst.System, dst.Bucket, dstPrefix, func() error {
+				return copyObject(g, src.System, src.Bucket, src.Prefix, dst.Bucket, dstPrefix)
+			})
 		})
 	case system.FileType_Invalid:
 		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", src.Bucket, src.Prefix)
@@ -256,52 +298,273 @@ func cloudCopy(src, dst *system.FileObject, forceCheckum, isRec bool, wg *sync.W
 	}
 }
 
-func localCopy(src, dst *system.FileObject, _, _ bool, wg *sync.WaitGroup) {
+func localCopy(src, dst *system.FileObject, _, _ bool, g *worker.Group) {
 	if src.FileType() == system.FileType_Invalid {
 		logger.Info(module, "Invalid local path: [%s]", src.Prefix)
 		common.Exit()
 	}
-	wg.Add(1)
-	pool.Add(func() {
-		defer wg.Done()
-		if e := src.System.Copy(src.Bucket, src.Prefix, dst.Bucket, dst.Prefix); e != nil {
-			common.Exit()
-		}
+	g.Go(func(context.Context) error {
+		return logTransfer(src.System, src.Bucket, src.Prefix, dst.System, dst.Bucket, dst.Prefix, func() error {
+			return src.System.Copy(src.Bucket, src.Prefix, dst.Bucket, dst.Prefix)
+		})
 	})
 }
 
-func doCopy(src, dst *system.FileObject, forceChecksum, isRec bool) {
-	var wg sync.WaitGroup
+// doCopy queues the transfers copying src to dst as jobs of g, whose Wait
+// returns once they have all finished.
+func doCopy(src, dst *system.FileObject, forceChecksum, isRec bool, g *worker.Group) {
 	if dst.Remote {
 		if !src.Remote {
-			upload(src, dst, forceChecksum, isRec, &wg)
+			upload(src, dst, forceChecksum, isRec, g)
 		} else {
-			cloudCopy(src, dst, forceChecksum, isRec, &wg)
+			cloudCopy(src, dst, forceChecksum, isRec, g)
 		}
 	} else {
 		if !src.Remote {
-			localCopy(src, dst, forceChecksum, isRec, &wg)
+			localCopy(src, dst, forceChecksum, isRec, g)
 		} else {
-			download(src, dst, forceChecksum, isRec, &wg)
+			download(src, dst, forceChecksum, isRec, g)
 		}
 	}
-	wg.Wait()
+}
+
+// fanOutTargets names where one source file lands in each destination
+func fanOutTargets(dsts []*system.FileObject, dstPath func(dst *system.FileObject) string) []*system.FileObject {
+	targets := make([]*system.FileObject, len(dsts))
+	for i, dst := range dsts {
+		targets[i] = &system.FileObject{System: dst.System, Bucket: dst.Bucket, Prefix: dstPath(dst), Remote: dst.Remote}
+	}
+	return targets
+}
+
+// fanOutFile queues one pool job reading src once into every target still to
+// be done, counting each target that fails rather than exiting on the first.
+func fanOutFile(src *system.FileObject, targets []*system.FileObject, g *worker.Group, failures *atomic.Int64) {
+	srcURL := urlOf(src.System, src.Bucket, src.Prefix)
+	pending := []*system.FileObject{}
+	for _, t := range targets {
+		if tlog.Done(srcURL, urlOf(t.System, t.Bucket, t.Prefix)) {
+			logger.Debug(module, "skipping %s -> %s: already recorded as successful", srcURL, t.Prefix)
+			continue
+		}
+		pending = append(pending, t)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	g.Go(func(context.Context) error {
+		start := time.Now()
+		errs := system.FanOut(src, pending, system.RunContext{Bars: bars, UploadLimit: uploadLimit, DownloadLimit: downloadLimit})
+		for i, t := range pending {
+			recordTransfer(srcURL, t.System, t.Bucket, t.Prefix, start, errs[i])
+			if errs[i] != nil {
+				logger.Info(module, "copying %s to %s failed with %s", srcURL, urlOf(t.System, t.Bucket, t.Prefix), errs[i])
+				failures.Add(1)
+			}
+		}
+		return nil
+	})
+}
+
+// fanOutCopy queues copies of src to every one of dsts, as cp --to does.
+// Unlike doCopy it never stages through a temp file, whatever the backends:
+// each file is streamed straight from its source into all destinations.
+func fanOutCopy(src *system.FileObject, dsts []*system.FileObject, isRec bool, g *worker.Group, failures *atomic.Int64) {
+	switch src.FileType() {
+	case system.FileType_Directory:
+		if !isRec {
+			logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do cp -r?)", src.Bucket, src.Prefix)
+			common.Exit()
+			return
+		}
+		root := src.Prefix
+		if !src.Remote {
+			root = linux.GetRealPath(src.Prefix)
+		}
+		if err := walkTree(src, isRec, func(obj *system.FileObject) error {
+			fanOutFile(obj, fanOutTargets(dsts, func(dst *system.FileObject) string {
+				return common.GetDstPath(root, obj.Prefix, dst.Prefix)
+			}), g, failures)
+			return nil
+		}); err != nil {
+			common.Exit()
+			return
+		}
+	case system.FileType_Object:
+		_, name := common.ParseFile(src.Prefix)
+		fanOutFile(src, fanOutTargets(dsts, func(dst *system.FileObject) string {
+			if dst.FileType() == system.FileType_Directory {
+				return common.JoinPath(dst.Prefix, name)
+			}
+			return dst.Prefix
+		}), g, failures)
+	case system.FileType_Invalid:
+		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", src.Bucket, src.Prefix)
+		common.Exit()
+	}
+}
+
+// scanSourceURLs splits the urls read by cp -I, calling fn for each as it is
+// read rather than after the whole list. The list is NUL separated, as from
+// find -print0, if a NUL appears in its first 64KiB, and newline separated
+// otherwise; blank lines and a trailing \r are ignored.
+func scanSourceURLs(r io.Reader, fn func(string)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(64 * 1024)
+	sep := byte('\n')
+	if bytes.IndexByte(head, 0) >= 0 {
+		sep = 0
+	}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		u := scanner.Text()
+		if sep == '\n' {
+			u = strings.TrimSuffix(u, "\r")
+		}
+		if strings.TrimSpace(u) == "" {
+			continue
+		}
+		fn(u)
+	}
+	return scanner.Err()
 }
 
 var cpCmd = &cobra.Command{
-	Use:   "cp [-v] [-r] [source url]... [destination url]",
+	Use:   "cp [-v] [-r] [-L log] [-I | --from-file file] [source url]... [destination url | --to url...]",
 	Short: "Copy files and objects",
-	Long:  "Copy files and objects",
-	Args:  cobra.MinimumNArgs(2),
+	Long: `Copy files and objects.
+
+With -I the source urls are read from stdin, and with --from-file from a file,
+instead of being given as arguments; the only argument is then the destination.
+Urls are one per line, or NUL separated as from find -print0. Every source is
+copied by the same pool in one process, as it is read.
+
+With --to, given once per destination, every argument is a source, and each
+source file is read once and streamed to all destinations in parallel. Every
+destination is verified on its own against the checksums of what was read,
+and one failing does not stop the others: each failure is reported, and cp
+exits non-zero once all copies have finished. --sha256 is not supported with
+--to.
+
+With -r the files of a tree are started in listing order. --schedule
+largest-first starts the largest first instead, so a run does not end on one
+large file while the other workers idle. --schedule balanced also batches files
+under 256KiB into shared jobs, and splits a download too small to give every
+worker a chunk into chunks of at least 4MiB.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		isRec, _ := cmd.Flags().GetBool("r")
 		forceChecksum, _ := cmd.Flags().GetBool("v")
-		dst := system.ParseFileObject(args[len(args)-1])
+		fromStdin, _ := cmd.Flags().GetBool("I")
+		fromFile, _ := cmd.Flags().GetString("from-file")
+		logPath, _ := cmd.Flags().GetString("L")
+		to, _ := cmd.Flags().GetStringArray("to")
+		schedule, _ := cmd.Flags().GetString("schedule")
+		listed := fromStdin || fromFile != ""
+		// With --to the destinations are flags, so no argument is one.
+		dstArgs := 1
+		if len(to) > 0 {
+			dstArgs = 0
+		}
+		switch {
+		case fromStdin && fromFile != "":
+			logger.Info(module, "-I and --from-file cannot be used together")
+			common.Exit()
+			return
+		case listed && len(args) != dstArgs:
+			logger.Info(module, "with -I or --from-file, the only argument is the destination url, if --to is not given")
+			common.Exit()
+			return
+		case !listed && len(args) < dstArgs+1:
+			logger.Info(module, "cp needs a source and a destination url")
+			common.Exit()
+			return
+		case len(to) > 0 && withSHA256:
+			logger.Info(module, "--sha256 cannot be used with --to")
+			common.Exit()
+			return
+		}
+		var err error
+		if scheduleStrategy, err = parseSchedule(schedule); err != nil {
+			logger.Info(module, "invalid --schedule: %s", err)
+			common.Exit()
+			return
+		}
+		setupTransferLog(logPath)
+
+		// The first copy to fail stops the rest. With --to a failed target is
+		// counted instead, and the copies go on.
+		g := pool.Group(context.Background()).FailFast()
+		var failures atomic.Int64
+		var copyFrom func(src *system.FileObject)
+		if len(to) > 0 {
+			dsts := make([]*system.FileObject, len(to))
+			for i, u := range to {
+				dsts[i] = system.ParseFileObject(u)
+			}
+			copyFrom = func(src *system.FileObject) {
+				keySource(src, dsts...)
+				fanOutCopy(src, dsts, isRec, g, &failures)
+			}
+		} else {
+			dst := system.ParseFileObject(args[len(args)-1])
+			copyFrom = func(src *system.FileObject) {
+				keySource(src, dst)
+				doCopy(src, dst, forceChecksum, isRec, g)
+			}
+		}
+		finish := func() {
+			if err := g.Wait(); err != nil {
+				logger.Info(module, "copy failed with %s", err)
+				common.Exit()
+			}
+			if n := failures.Load(); n > 0 {
+				logger.Info(module, "%d copy(s) failed", n)
+				common.Exit()
+			}
+		}
 
-		for i := 0; i < len(args)-1; i++ {
-			src := system.ParseFileObject(parseStdIn(args[i]))
-			doCopy(src, dst, forceChecksum, isRec)
+		if !listed {
+			for i := 0; i < len(args)-dstArgs; i++ {
+				copyFrom(system.ParseFileObject(parseStdIn(args[i])))
+			}
+			finish()
+			return
+		}
+
+		var in io.Reader = os.Stdin
+		if fromFile != "" {
+			f, err := os.Open(fromFile)
+			if err != nil {
+				logger.Info(module, "open source list failed with %s", err)
+				common.Exit()
+				return
+			}
+			defer func() { _ = f.Close() }()
+			in = f
+		}
+		count := 0
+		err = scanSourceURLs(in, func(u string) {
+			count++
+			copyFrom(system.ParseFileObject(u))
+		})
+		finish()
+		if err != nil {
+			logger.Info(module, "read source list failed with %s", err)
+			common.Exit()
+			return
 		}
+		logger.Debug(module, "copied %d source url(s) from the list", count)
 	},
 }
 
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanSourceURLs(t *testing.T) {
+	scan := func(in string) []string {
+		urls := []string{}
+		assert.NoError(t, scanSourceURLs(strings.NewReader(in), func(u string) { urls = append(urls, u) }))
+		return urls
+	}
+	assert.Equal(t, []string{"gs://b/a", "s3://b/c d", "/tmp/e"}, scan("gs://b/a\ns3://b/c d\r\n\n/tmp/e"))
+	// NUL separated, as from find -print0: a newline is part of a name.
+	assert.Equal(t, []string{"/tmp/a\nb", "/tmp/c"}, scan("/tmp/a\nb\x00/tmp/c\x00"))
+	assert.Equal(t, []string{}, scan(""))
+}
+
+// keyedSystem records the keys SourceKey is given
+type keyedSystem struct {
+	system.ISystem
+	keys map[string]string
+}
+
+func (k *keyedSystem) SourceKey(bucket, key string) error {
+	k.keys[bucket] = key
+	return nil
+}
+
+func TestKeySource(t *testing.T) {
+	defer func() { sourceKey = "" }()
+	k := &keyedSystem{keys: map[string]string{}}
+	src := &system.FileObject{System: k, Bucket: "plain", Remote: true}
+	dst := &system.FileObject{System: k, Bucket: "secret", Remote: true}
+
+	keySource(src, dst)
+	assert.Empty(t, k.keys, "without the flag sources keep the other flags' keys")
+
+	sourceKey = noSourceKey
+	keySource(src, dst)
+	key, ok := k.keys["plain"]
+	assert.True(t, ok)
+	assert.Equal(t, "", key)
+
+	sourceKey = "c291cmNl"
+	keySource(src, dst)
+	assert.Equal(t, "c291cmNl", k.keys["plain"])
+
+	// A bucket both read and written keeps one key.
+	delete(k.keys, "secret")
+	keySource(dst, dst)
+	_, ok = k.keys["secret"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/nextbillion-ai/gsg/worker"
+)
+
+// deleteBatchSize is how many keys one job deletes from a backend that
+// deletes in bulk; S3 takes up to this many in a request.
+const deleteBatchSize = 1000
+
+// deleter queues the deletion of objects of one bucket as jobs of a group:
+// one job per object, or one per batch where the backend is a
+// system.BatchDeleter. A failure that outlasts its retries fails its job.
+type deleter struct {
+	g      *worker.Group
+	sys    system.ISystem
+	bucket string
+	batch  []string
+}
+
+func newDeleter(g *worker.Group, sys system.ISystem, bucket string) *deleter {
+	return &deleter{g: g, sys: sys, bucket: bucket}
+}
+
+// Add queues prefix for deletion
+func (d *deleter) Add(prefix string) {
+	if _, ok := d.sys.(system.BatchDeleter); !ok {
+		d.g.Go(func(context.Context) error {
+			return common.DoWithRetrySimple(func() error {
+				return d.sys.Delete(d.bucket, prefix)
+			})
+		})
+		return
+	}
+	d.batch = append(d.batch, prefix)
+	if len(d.batch) == deleteBatchSize {
+		d.Flush()
+	}
+}
+
+// Flush queues what is left of the current batch. It has to be called once
+// everything has been added.
+func (d *deleter) Flush() {
+	if len(d.batch) == 0 {
+		return
+	}
+	prefixes := d.batch
+	d.batch = nil
+	d.g.Go(func(context.Context) error {
+		if n := d.deleteBatch(prefixes); n > 0 {
+			return fmt.Errorf("%d of %d objects could not be removed", n, len(prefixes))
+		}
+		return nil
+	})
+}
+
+// deleteBatch deletes prefixes, retrying just the keys that failed, and
+// returns how many could not be deleted, each of which it logs.
+func (d *deleter) deleteBatch(prefixes []string) int {
+	bd := d.sys.(system.BatchDeleter)
+	var errs []error
+	_ = common.DoWithRetrySimple(func() error {
+		var failed []string
+		var failures []error
+		for i, err := range bd.DeleteObjects(d.bucket, prefixes) {
+			if err != nil {
+				failed = append(failed, prefixes[i])
+				failures = append(failures, err)
+			}
+		}
+		prefixes, errs = failed, failures
+		if len(failed) > 0 {
+			return fmt.Errorf("%d of a batch of deletes failed", len(failed))
+		}
+		return nil
+	})
+	for i, err := range errs {
+		fo := system.FileObject{System: d.sys, Bucket: d.bucket, Prefix: prefixes[i]}
+		logger.Info(module, "removing %s failed with %s", fo.GetFullPath(), err)
+	}
+	return len(errs)
+}
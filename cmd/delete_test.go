@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/nextbillion-ai/gsg/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyDeleter fails the keys in flaky once and those in broken always
+type flakyDeleter struct {
+	system.ISystem
+	mu     sync.Mutex
+	flaky  map[string]bool
+	broken map[string]bool
+	calls  [][]string
+}
+
+func (f *flakyDeleter) Scheme() string { return "fake" }
+
+func (f *flakyDeleter) DeleteObjects(_ string, prefixes []string) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, append([]string{}, prefixes...))
+	errs := make([]error, len(prefixes))
+	for i, p := range prefixes {
+		if f.broken[p] {
+			errs[i] = errors.New("AccessDenied")
+		} else if f.flaky[p] {
+			delete(f.flaky, p)
+			errs[i] = errors.New("InternalError")
+		}
+	}
+	return errs
+}
+
+func TestDeleteBatch(t *testing.T) {
+	f := &flakyDeleter{flaky: map[string]bool{"b": true}, broken: map[string]bool{"c": true}}
+	d := newDeleter(worker.Inline(context.Background()), f, "bucket")
+	assert.Equal(t, 1, d.deleteBatch([]string{"a", "b", "c", "d"}))
+	// Retries carry only the keys that failed.
+	assert.Equal(t, [][]string{{"a", "b", "c", "d"}, {"b", "c"}, {"c"}}, f.calls)
+
+	f.calls = nil
+	assert.Equal(t, 0, d.deleteBatch([]string{"a", "d"}))
+	assert.Equal(t, [][]string{{"a", "d"}}, f.calls)
+}
+
+func TestDeleterErrors(t *testing.T) {
+	f := &flakyDeleter{broken: map[string]bool{"c": true}}
+	g := worker.Inline(context.Background())
+	d := newDeleter(g, f, "bucket")
+	for _, p := range []string{"a", "c"} {
+		d.Add(p)
+	}
+	d.Flush()
+	// The batch that could not be deleted whole fails the group, which the
+	// command waits on.
+	err := g.Wait()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 objects could not be removed")
+}
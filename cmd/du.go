@@ -31,7 +31,30 @@ var duCmd = &cobra.Command{
 		}
 		var objs []system.DiskUsage
 		var err error
-		if objs, err = fo.System.DiskUsage(fo.Bucket, fo.Prefix, true); err != nil {
+		if fo.Remote && fo.FileType() == system.FileType_Directory && (isSum || parallelListing > 1) {
+			// The total alone needs no tree of every name under the prefix,
+			// so it is summed as the listing arrives. The tree is built here
+			// too when the listing is parallel, as the backends list in turn.
+			total := int64(0)
+			root := system.NewDUTree(fo.Prefix, 0, true)
+			if err = walkTree(fo, true, func(obj *system.FileObject) error {
+				size := int64(0)
+				if obj.Attributes != nil {
+					size = obj.Attributes.Size
+				}
+				total += size
+				if !isSum {
+					root.Add(obj.Prefix, size, fo.Prefix)
+				}
+				return nil
+			}); err != nil {
+				common.Exit()
+			}
+			objs = []system.DiskUsage{{Size: total, Name: root.Name}}
+			if !isSum {
+				objs = root.ToDiskUsages()
+			}
+		} else if objs, err = fo.System.DiskUsage(fo.Bucket, fo.Prefix, true); err != nil {
 			common.Exit()
 		}
 		scheme := ""
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/nextbillion-ai/gsg/common"
 	"github.com/nextbillion-ai/gsg/logger"
@@ -11,27 +15,212 @@ import (
 )
 
 func init() {
+	hashCmd.Flags().BoolP("r", "r", false, "hash an entire directory tree")
+	hashCmd.Flags().Bool("crc32c", false, "print the crc32c")
+	hashCmd.Flags().Bool("md5", false, "print the md5")
+	hashCmd.Flags().Bool("json", false, "print one json object per file instead of checksum lines")
 	rootCmd.AddCommand(hashCmd)
 }
 
-var hashCmd = &cobra.Command{
-	Use:   "hash [url]",
-	Short: "Get checksum value of objects",
-	Long:  "Get checksum value of objects",
-	Args:  cobra.ExactArgs(1),
-	Run: func(_ *cobra.Command, args []string) {
-		fo := system.ParseFileObject(args[0])
+// hashAlgorithms are the algorithms hash can print, in output order
+var hashAlgorithms = []string{"crc32c", "md5", "sha256"}
+
+type hashResult struct {
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	CRC32C string `json:"crc32c,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func (r *hashResult) get(algorithm string) string {
+	switch algorithm {
+	case "crc32c":
+		return r.CRC32C
+	case "md5":
+		return r.MD5
+	default:
+		return r.SHA256
+	}
+}
+
+// hashFile computes the requested checksums of one file or object. Local
+// files are hashed here; remote objects report what their backend stored,
+// and a checksum the backend does not have is an error rather than a blank.
+func hashFile(fo *system.FileObject, algorithms map[string]bool) (*hashResult, error) {
+	attrs := fo.Attributes
+	if attrs == nil {
 		var err error
-		var attrs *system.Attrs
 		if attrs, err = fo.System.Attributes(fo.Bucket, fo.Prefix); err != nil {
-			common.Exit()
+			return nil, err
 		}
 		if attrs == nil {
-			logger.Info(module, "Invalid bucket[%s] with prefix[%s]", fo.Bucket, fo.Prefix)
-			common.Exit()
+			return nil, fmt.Errorf("not an object")
+		}
+	}
+	r := &hashResult{URL: fo.Prefix, Size: attrs.Size}
+	if fo.Remote {
+		r.URL = fo.GetFullPath()
+	}
+	if algorithms["crc32c"] {
+		var crc uint32
+		if fo.Remote {
+			// A backend without one reports 0, which no more than one in
+			// four billion non-empty objects really has.
+			if crc = attrs.GetCRC32C(); crc == 0 && attrs.Size > 0 {
+				return nil, fmt.Errorf("crc32c unavailable")
+			}
+		} else {
+			crc = common.GetFileCRC32C(fo.Prefix)
+		}
+		r.CRC32C = fmt.Sprintf("%08x", crc)
+	}
+	if algorithms["md5"] {
+		sum := attrs.MD5
+		if !fo.Remote {
+			sum = common.GetFileMD5(fo.Prefix)
+		}
+		if sum == nil {
+			return nil, fmt.Errorf("md5 unavailable")
+		}
+		r.MD5 = hex.EncodeToString(sum)
+	}
+	if algorithms["sha256"] {
+		if r.SHA256 = attrs.GetSHA256(); r.SHA256 == "" {
+			return nil, fmt.Errorf("sha256 unavailable (upload with --sha256 to store one)")
+		}
+	}
+	return r, nil
+}
+
+// writeHashResult prints a result as a json line, or in the form the matching
+// *sum tool reads back with -c: "<hex>  <name>" for a single algorithm, and
+// the BSD tag form "SHA256 (<name>) = <hex>" when several are printed, since
+// only that form names the algorithm on each line.
+func writeHashResult(r *hashResult, algorithms []string, asJSON bool) {
+	if asJSON {
+		b, _ := json.Marshal(r)
+		logger.Output(string(b) + "\n")
+		return
+	}
+	for _, a := range algorithms {
+		if len(algorithms) == 1 {
+			logger.Output(fmt.Sprintf("%s  %s\n", r.get(a), r.URL))
+		} else {
+			logger.Output(fmt.Sprintf("%s (%s) = %s\n", strings.ToUpper(a), r.URL, r.get(a)))
+		}
+	}
+}
+
+// legacyHash prints what hash printed before it took any flags, so scripts
+// parsing that output keep working.
+func legacyHash(fo *system.FileObject) {
+	var err error
+	var attrs *system.Attrs
+	if attrs, err = fo.System.Attributes(fo.Bucket, fo.Prefix); err != nil {
+		common.Exit()
+	}
+	if attrs == nil {
+		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", fo.Bucket, fo.Prefix)
+		common.Exit()
+		return
+	}
+	// The local backend leaves CRC32 to be computed on demand.
+	if attrs.CalcCRC32C != nil {
+		attrs.CRC32 = attrs.CalcCRC32C()
+	}
+	logger.Output(fmt.Sprintf("%-20s%d\n", "Hash (CRC32C):", attrs.CRC32))
+	// Only a stored sha256: computing one for a local file is what --sha256 is for.
+	if attrs.SHA256 != "" {
+		logger.Output(fmt.Sprintf("%-20s%s\n", "Hash (SHA256):", attrs.SHA256))
+	}
+	logger.Output(fmt.Sprintf("%-20s%s\n", "ModTime:", attrs.ModTime.UTC().String()))
+}
+
+var hashCmd = &cobra.Command{
+	Use:   "hash [-r] [--crc32c] [--md5] [--sha256] [--json] [url]...",
+	Short: "Get checksum value of objects",
+	Long: `Get checksum value of files and objects.
+
+Local files are hashed locally. Remote objects report the checksums their
+backend stored; sha256 is only stored for objects uploaded with --sha256.
+Without any algorithm or --json flag, the crc32c and mtime of a single url are
+printed as before.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isRec, _ := cmd.Flags().GetBool("r")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		selected := map[string]bool{}
+		selected["crc32c"], _ = cmd.Flags().GetBool("crc32c")
+		selected["md5"], _ = cmd.Flags().GetBool("md5")
+		// --sha256 is the persistent flag that also selects uploading with it.
+		selected["sha256"] = withSHA256
+
+		legacy := !isRec && !asJSON && !selected["crc32c"] && !selected["md5"] && !selected["sha256"]
+		if legacy && len(args) == 1 {
+			legacyHash(system.ParseFileObject(args[0]))
 			return
 		}
-		logger.Output(fmt.Sprintf("%-20s%d\n", "Hash (CRC32C):", attrs.CRC32))
-		logger.Output(fmt.Sprintf("%-20s%s\n", "ModTime:", attrs.ModTime.UTC().String()))
+		algorithms := []string{}
+		for _, a := range hashAlgorithms {
+			if selected[a] {
+				algorithms = append(algorithms, a)
+			}
+		}
+		if len(algorithms) == 0 {
+			selected["crc32c"] = true
+			algorithms = []string{"crc32c"}
+		}
+
+		fos := []*system.FileObject{}
+		for _, arg := range args {
+			fo := system.ParseFileObject(arg)
+			switch fo.FileType() {
+			case system.FileType_Object:
+				fos = append(fos, fo)
+			case system.FileType_Directory:
+				if !isRec {
+					logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do hash -r?)", fo.Bucket, fo.Prefix)
+					common.Exit()
+					return
+				}
+				objs, err := fo.System.List(fo.Bucket, fo.Prefix, true)
+				if err != nil {
+					common.Exit()
+					return
+				}
+				fos = append(fos, objs...)
+			default:
+				logger.Info(module, "Invalid bucket[%s] with prefix[%s]", fo.Bucket, fo.Prefix)
+				common.Exit()
+				return
+			}
+		}
+
+		// Hashed through the pool, which is what makes -m worth passing for
+		// local trees; printed afterwards in listing order.
+		results := make([]*hashResult, len(fos))
+		g := pool.Group(context.Background())
+		for i, fo := range fos {
+			g.Go(func(ctx context.Context) error {
+				r, err := hashFile(fo, selected)
+				if err != nil {
+					return fmt.Errorf("hashing [%s] failed with %w", fo.Prefix, err)
+				}
+				results[i] = r
+				return nil
+			})
+		}
+		err := g.Wait()
+
+		for _, r := range results {
+			if r != nil {
+				writeHashResult(r, algorithms, asJSON)
+			}
+		}
+		if err != nil {
+			logger.Info(module, "%s", err)
+			common.Exit()
+		}
 	},
 }
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The local backend leaves CRC32 zero for Attributes callers to compute, which
+// is how hash used to print 0 for every local file.
+func TestHashFileLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	fo := system.ParseFileObject(path)
+	assert.Equal(t, system.FileType_Object, fo.FileType())
+
+	r, err := hashFile(fo, map[string]bool{"crc32c": true, "md5": true, "sha256": true})
+	assert.NoError(t, err)
+	assert.Equal(t, path, r.URL)
+	assert.Equal(t, int64(5), r.Size)
+	assert.Equal(t, "9a71bb4c", r.CRC32C)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", r.MD5)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", r.SHA256)
+
+	r, err = hashFile(fo, map[string]bool{"md5": true})
+	assert.NoError(t, err)
+	assert.Equal(t, "", r.CRC32C)
+	assert.Equal(t, "", r.SHA256)
+}
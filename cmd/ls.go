@@ -28,31 +28,42 @@ var lsCmd = &cobra.Command{
 		isHuman, _ := cmd.Flags().GetBool("h")
 		isLong, _ := cmd.Flags().GetBool("l")
 		fo := system.ParseFileObject(args[0])
-		var err error
-		var objs []*system.FileObject
-		if objs, err = fo.System.List(fo.Bucket, fo.Prefix, isRec); err != nil {
+		// Printed a batch at a time as the listing arrives, rather than once
+		// all of it is in memory.
+		count := 0
+		sizeWidth := 0
+		outputs := []*output{}
+		if err := walkTree(fo, isRec, func(obj *system.FileObject) error {
+			count++
+			outputs = append(outputs, build(obj, isHuman, isLong))
+			if len(outputs) == lsBatch {
+				sizeWidth = write(outputs, sizeWidth)
+				outputs = outputs[:0]
+			}
+			return nil
+		}); err != nil {
 			common.Exit()
 		}
-		if len(objs) == 0 {
+		if count == 0 {
 			logger.Info(module, "No objects found with bucket[%s] with prefix[%s]", fo.Bucket, fo.Prefix)
 			common.Exit()
 		}
-		outputs := []*output{}
-		for _, obj := range objs {
-			outputs = append(outputs, build(obj, isHuman, isLong))
-		}
-		write(outputs)
+		write(outputs, sizeWidth)
 	},
 }
 
+// lsBatch is how many entries ls lines up the sizes of before printing them
+const lsBatch = 1000
+
 type output struct {
 	size string
 	date string
 	file string
 }
 
-func write(outputs []*output) {
-	sizeWidth := 0
+// write prints outputs with sizes right-aligned to at least sizeWidth, and
+// returns the width used so that later batches line up with this one.
+func write(outputs []*output, sizeWidth int) int {
 	for _, o := range outputs {
 		sizeLen := len(o.size)
 		if sizeWidth < sizeLen {
@@ -66,6 +77,7 @@ func write(outputs []*output) {
 			logger.Output(o.file + "\n")
 		}
 	}
+	return sizeWidth
 }
 
 func build(obj *system.FileObject, isHuman, isLong bool) *output {
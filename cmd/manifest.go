@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	manifestCreateCmd.Flags().String("format", "", "manifest format, jsonl or csv (default from the file extension, else jsonl)")
+	manifestCmd.AddCommand(manifestCreateCmd)
+	manifestCmd.AddCommand(manifestVerifyCmd)
+	rootCmd.AddCommand(manifestCmd)
+}
+
+const (
+	manifestJSONL = "jsonl"
+	manifestCSV   = "csv"
+)
+
+var manifestCSVHeader = []string{"path", "size", "mtime", "crc32c", "md5", "version"}
+
+// manifestEntry is one file of a manifest. Path is relative to the url the
+// manifest was created from, so a manifest verifies a copy anywhere.
+type manifestEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"`
+	CRC32C  string `json:"crc32c"`
+	MD5     string `json:"md5,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// differs reports how e and o disagree, or "" when they agree. A checksum is
+// only compared when both sides have one: an s3 object uploaded in parts has
+// no md5 and may have no crc32c. mtime and version are recorded for the
+// reader's benefit and never compared, since a faithful copy changes both.
+func (e *manifestEntry) differs(o *manifestEntry) string {
+	if e.Size != o.Size {
+		return fmt.Sprintf("size %d != %d", e.Size, o.Size)
+	}
+	if e.CRC32C != "" && o.CRC32C != "" && e.CRC32C != o.CRC32C {
+		return fmt.Sprintf("crc32c %s != %s", e.CRC32C, o.CRC32C)
+	}
+	if e.MD5 != "" && o.MD5 != "" && e.MD5 != o.MD5 {
+		return fmt.Sprintf("md5 %s != %s", e.MD5, o.MD5)
+	}
+	return ""
+}
+
+// manifestFormat picks the format for a manifest file: the flag if given,
+// otherwise its extension.
+func manifestFormat(flag, path string) (string, error) {
+	switch flag {
+	case manifestJSONL, manifestCSV:
+		return flag, nil
+	case "":
+		if strings.HasSuffix(strings.ToLower(path), ".csv") {
+			return manifestCSV, nil
+		}
+		return manifestJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown manifest format %q", flag)
+	}
+}
+
+func writeManifest(w io.Writer, entries []*manifestEntry, format string) error {
+	if format == manifestCSV {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(manifestCSVHeader); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := cw.Write([]string{e.Path, strconv.FormatInt(e.Size, 10), e.ModTime, e.CRC32C, e.MD5, e.Version}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readManifest(r io.Reader, format string) ([]*manifestEntry, error) {
+	entries := []*manifestEntry{}
+	if format == manifestCSV {
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, rec := range records {
+			if i == 0 && len(rec) > 0 && rec[0] == manifestCSVHeader[0] {
+				continue
+			}
+			if len(rec) != len(manifestCSVHeader) {
+				return nil, fmt.Errorf("line %d: expected %d fields, got %d", i+1, len(manifestCSVHeader), len(rec))
+			}
+			size, err := strconv.ParseInt(rec[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid size %q", i+1, rec[1])
+			}
+			entries = append(entries, &manifestEntry{Path: rec[0], Size: size, ModTime: rec[2], CRC32C: rec[3], MD5: rec[4], Version: rec[5]})
+		}
+		return entries, nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		e := &manifestEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// compareManifest returns the paths expected but absent, present but not
+// expected, and present with different content, each sorted.
+func compareManifest(expected, actual []*manifestEntry) (missing, extra, mismatched []string) {
+	have := map[string]*manifestEntry{}
+	for _, e := range actual {
+		have[e.Path] = e
+	}
+	seen := map[string]bool{}
+	for _, e := range expected {
+		seen[e.Path] = true
+		a, ok := have[e.Path]
+		if !ok {
+			missing = append(missing, e.Path)
+			continue
+		}
+		if d := e.differs(a); d != "" {
+			mismatched = append(mismatched, fmt.Sprintf("%s: %s", e.Path, d))
+		}
+	}
+	for _, a := range actual {
+		if !seen[a.Path] {
+			extra = append(extra, a.Path)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	sort.Strings(mismatched)
+	return
+}
+
+// toManifestEntry builds the entry for one listed file. Local checksums are
+// computed, remote ones are what the backend stored.
+func toManifestEntry(rp string, fo *system.FileObject) *manifestEntry {
+	attrs := fo.Attributes
+	e := &manifestEntry{
+		Path:    rp,
+		Size:    attrs.Size,
+		ModTime: attrs.ModTime.UTC().Format(time.RFC3339Nano),
+		Version: attrs.Version,
+	}
+	var crc uint32
+	md5 := attrs.MD5
+	if fo.Remote {
+		crc = attrs.GetCRC32C()
+	} else {
+		crc, md5 = common.GetFileCRC32C(fo.Prefix), common.GetFileMD5(fo.Prefix)
+	}
+	// A remote crc of 0 on a non-empty object means the backend stored none;
+	// leave it empty so differs skips it rather than reporting a mismatch.
+	if !fo.Remote || crc != 0 || attrs.Size == 0 {
+		e.CRC32C = fmt.Sprintf("%08x", crc)
+	}
+	if md5 != nil {
+		e.MD5 = hex.EncodeToString(md5)
+	}
+	return e
+}
+
+// collectManifest lists a url recursively and builds its entries through the
+// pool, sorted by path.
+func collectManifest(base *system.FileObject) []*manifestEntry {
+	files := map[string]*system.FileObject{}
+	switch base.FileType() {
+	case system.FileType_Object:
+		_, name := common.ParseFile(base.Prefix)
+		files[name] = base
+	case system.FileType_Directory:
+		files = listRelatively(base, true)
+	default:
+		logger.Info(module, "Invalid bucket[%s] with prefix[%s]", base.Bucket, base.Prefix)
+		common.Exit()
+		return nil
+	}
+
+	entries := make([]*manifestEntry, 0, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for rp, fo := range files {
+		wg.Add(1)
+		pool.Add(func() {
+			defer wg.Done()
+			e := toManifestEntry(rp, fo)
+			mu.Lock()
+			entries = append(entries, e)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Create or verify a checksummed inventory",
+	Long:  "Create or verify a checksummed inventory of files and objects",
+}
+
+var manifestCreateCmd = &cobra.Command{
+	Use:   "create [--format jsonl|csv] [url] [manifest file]",
+	Short: "Write a manifest of every file under a url",
+	Long:  "Write a manifest of every file under a url: relative path, size, mtime, crc32c, md5 and generation or etag",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flag, _ := cmd.Flags().GetString("format")
+		format, err := manifestFormat(flag, args[1])
+		if err != nil {
+			logger.Info(module, "%s", err)
+			common.Exit()
+			return
+		}
+		entries := collectManifest(system.ParseFileObject(args[0]))
+		f, err := os.Create(args[1])
+		if err != nil {
+			logger.Info(module, "create manifest failed with %s", err)
+			common.Exit()
+			return
+		}
+		defer func() { _ = f.Close() }()
+		w := bufio.NewWriter(f)
+		if err = writeManifest(w, entries, format); err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			logger.Info(module, "write manifest failed with %s", err)
+			common.Exit()
+			return
+		}
+		logger.Info(module, "Wrote %d entries to %s", len(entries), args[1])
+	},
+}
+
+var manifestVerifyCmd = &cobra.Command{
+	Use:   "verify [url] [manifest file]",
+	Short: "Check a url against a manifest",
+	Long:  "Check a url against a manifest, reporting missing, extra and mismatched files; exits non-zero on any drift",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := manifestFormat("", args[1])
+		f, err := os.Open(args[1])
+		if err != nil {
+			logger.Info(module, "open manifest failed with %s", err)
+			common.Exit()
+			return
+		}
+		expected, err := readManifest(f, format)
+		_ = f.Close()
+		if err != nil {
+			logger.Info(module, "read manifest %s failed with %s", args[1], err)
+			common.Exit()
+			return
+		}
+		actual := collectManifest(system.ParseFileObject(args[0]))
+		missing, extra, mismatched := compareManifest(expected, actual)
+		for _, p := range missing {
+			logger.Output(fmt.Sprintf("MISSING  %s\n", p))
+		}
+		for _, p := range extra {
+			logger.Output(fmt.Sprintf("EXTRA    %s\n", p))
+		}
+		for _, p := range mismatched {
+			logger.Output(fmt.Sprintf("MISMATCH %s\n", p))
+		}
+		if len(missing)+len(extra)+len(mismatched) > 0 {
+			logger.Info(module, "Drift detected: %d missing, %d extra, %d mismatched of %d entries",
+				len(missing), len(extra), len(mismatched), len(expected))
+			common.Exit()
+			return
+		}
+		logger.Info(module, "Verified %d entries", len(expected))
+	},
+}
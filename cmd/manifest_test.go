@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	entries := []*manifestEntry{
+		{Path: "a.txt", Size: 5, ModTime: "2026-01-02T03:04:05Z", CRC32C: "9a71bb4c", MD5: "5d41402abc4b2a76b9719d911017c592", Version: "1700000000000000"},
+		// A comma and a quote must survive csv.
+		{Path: `dir/b, "c".bin`, Size: 0, ModTime: "2026-01-02T03:04:05Z", CRC32C: "00000000"},
+	}
+	for _, format := range []string{manifestJSONL, manifestCSV} {
+		var buf bytes.Buffer
+		assert.NoError(t, writeManifest(&buf, entries, format))
+		got, err := readManifest(&buf, format)
+		assert.NoError(t, err, format)
+		assert.Equal(t, entries, got, format)
+	}
+}
+
+func TestManifestFormat(t *testing.T) {
+	f, err := manifestFormat("", "inventory.CSV")
+	assert.NoError(t, err)
+	assert.Equal(t, manifestCSV, f)
+	f, err = manifestFormat("", "inventory.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, manifestJSONL, f)
+	f, err = manifestFormat(manifestJSONL, "inventory.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, manifestJSONL, f)
+	_, err = manifestFormat("xml", "inventory.xml")
+	assert.Error(t, err)
+}
+
+func TestCompareManifest(t *testing.T) {
+	expected := []*manifestEntry{
+		{Path: "same", Size: 1, CRC32C: "01", MD5: "aa"},
+		{Path: "gone", Size: 1, CRC32C: "01"},
+		{Path: "resized", Size: 1, CRC32C: "01"},
+		{Path: "changed", Size: 1, CRC32C: "01"},
+		// No md5 on one side, e.g. a multipart s3 upload: not a mismatch.
+		{Path: "nomd5", Size: 1, CRC32C: "01", MD5: "aa"},
+	}
+	actual := []*manifestEntry{
+		{Path: "same", Size: 1, CRC32C: "01", MD5: "aa", ModTime: "later", Version: "2"},
+		{Path: "resized", Size: 2, CRC32C: "01"},
+		{Path: "changed", Size: 1, CRC32C: "02"},
+		{Path: "nomd5", Size: 1, CRC32C: "01"},
+		{Path: "new", Size: 1, CRC32C: "01"},
+	}
+	missing, extra, mismatched := compareManifest(expected, actual)
+	assert.Equal(t, []string{"gone"}, missing)
+	assert.Equal(t, []string{"new"}, extra)
+	assert.Equal(t, []string{"changed: crc32c 01 != 02", "resized: size 1 != 2"}, mismatched)
+}
+
+func TestToManifestEntry(t *testing.T) {
+	remote := func(size int64, crc uint32) *system.FileObject {
+		return &system.FileObject{Remote: true, Attributes: &system.Attrs{Size: size, CRC32: crc}}
+	}
+	assert.Equal(t, "c99465aa", toManifestEntry("a", remote(11, 0xc99465aa)).CRC32C)
+	assert.Equal(t, "00000000", toManifestEntry("a", remote(0, 0)).CRC32C)
+	// No stored crc, e.g. an s3 object uploaded without one.
+	assert.Equal(t, "", toManifestEntry("a", remote(11, 0)).CRC32C)
+}
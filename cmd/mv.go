@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"context"
+
 	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
 	"github.com/nextbillion-ai/gsg/system"
 
 	"github.com/spf13/cobra"
@@ -21,27 +24,35 @@ var mvCmd = &cobra.Command{
 		isRec, _ := cmd.Flags().GetBool("r")
 		src := system.ParseFileObject(args[0])
 		dst := system.ParseFileObject(args[1])
-		doCopy(src, dst, true, isRec)
+		keySource(src, dst)
+		// Sources are only removed once every copy has succeeded.
+		copies := pool.Group(context.Background()).FailFast()
+		doCopy(src, dst, true, isRec, copies)
 		var err error
+		if err = copies.Wait(); err != nil {
+			logger.Info(module, "move failed with %s", err)
+			common.Exit()
+			return
+		}
+		deletes := pool.Group(context.Background()).FailFast()
 		switch src.FileType() {
 		case system.FileType_Directory:
-			var objs []*system.FileObject
-			if objs, err = src.System.List(src.Bucket, src.Prefix, isRec); err != nil {
+			d := newDeleter(deletes, src.System, src.Bucket)
+			if err = walkTree(src, isRec, func(obj *system.FileObject) error {
+				d.Add(obj.Prefix)
+				return nil
+			}); err != nil {
 				common.Exit()
 			}
-			for _, obj := range objs {
-				prefix := obj.Prefix
-				pool.Add(func() {
-					if e := src.System.Delete(src.Bucket, prefix); e != nil {
-						common.Exit()
-					}
-				})
-			}
+			d.Flush()
 		case system.FileType_Object:
 			if err = src.System.Delete(src.Bucket, src.Prefix); err != nil {
 				common.Exit()
 			}
 		}
-
+		if err = deletes.Wait(); err != nil {
+			logger.Info(module, "move failed with %s", err)
+			common.Exit()
+		}
 	},
 }
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+
 	"github.com/nextbillion-ai/gsg/common"
 	"github.com/nextbillion-ai/gsg/logger"
 	"github.com/nextbillion-ai/gsg/system"
@@ -21,6 +23,8 @@ var rmCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		isRec, _ := cmd.Flags().GetBool("r")
 
+		// The first delete to fail stops the rest.
+		g := pool.Group(context.Background()).FailFast()
 		for _, arg := range args {
 			fo := system.ParseFileObject(arg)
 			if fo.FileType() == system.FileType_Invalid {
@@ -31,34 +35,30 @@ var rmCmd = &cobra.Command{
 			case true:
 				switch fo.FileType() {
 				case system.FileType_Directory:
-					var err error
-					var objs []*system.FileObject
-					if objs, err = fo.System.List(fo.Bucket, fo.Prefix, isRec); err != nil {
+					// Deletes start with the first page of the listing, and
+					// hold it back while the pool is busy.
+					d := newDeleter(g, fo.System, fo.Bucket)
+					if err := walkTree(fo, isRec, func(obj *system.FileObject) error {
+						d.Add(obj.Prefix)
+						return nil
+					}); err != nil {
 						common.Exit()
 					}
-					for _, obj := range objs {
-						bucket := obj.Bucket
-						prefix := obj.Prefix
-						pool.Add(func() {
-							if e := fo.System.Delete(bucket, prefix); e != nil {
-								common.Exit()
-							}
-						})
-					}
+					d.Flush()
 				case system.FileType_Object:
-					pool.Add(func() {
-						if e := fo.System.Delete(fo.Bucket, fo.Prefix); e != nil {
-							common.Exit()
-						}
+					g.Go(func(context.Context) error {
+						return fo.System.Delete(fo.Bucket, fo.Prefix)
 					})
 				}
 			case false:
-				pool.Add(func() {
-					if e := fo.System.Delete(fo.Bucket, fo.Prefix); e != nil {
-						common.Exit()
-					}
+				g.Go(func(context.Context) error {
+					return fo.System.Delete(fo.Bucket, fo.Prefix)
 				})
 			}
 		}
+		if err := g.Wait(); err != nil {
+			logger.Info(module, "remove failed with %s", err)
+			common.Exit()
+		}
 	},
 }
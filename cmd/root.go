@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nextbillion-ai/gsg/bar"
@@ -27,6 +29,24 @@ var (
 	multiThread       int
 	chunkSize         int64
 	gentleIO          bool
+	adaptive          bool
+	withSHA256        bool
+	limitRate         string
+	limitUploadRate   string
+	limitDownloadRate string
+	maxReadOps        string
+	maxWriteOps       string
+	parallelListing   int
+	gcsEndpoint       string
+	impersonate       string
+	anonymous         bool
+	billingProject    string
+	requestPayer      bool
+	kmsKey            string
+	encryptionKey     string
+	sourceKey         string
+	uploadLimit       *common.ScheduledLimiter
+	downloadLimit     *common.ScheduledLimiter
 	bars              *bar.Container
 	pool              *worker.Pool
 )
@@ -43,6 +63,10 @@ func init() {
 		&multiThread, "c", "c", 64,
 		"set concurrency of execution workers, limit from 1 to 1000",
 	)
+	rootCmd.PersistentFlags().BoolVar(
+		&adaptive, "adaptive", false,
+		"with -m, start with few workers and adjust up to -c by throughput and throttling",
+	)
 	rootCmd.PersistentFlags().Int64Var(
 		&chunkSize, "chunk-size", -1,
 		"set download chunk size in bytes (default 16MB, 0 to disable chunking)",
@@ -51,6 +75,70 @@ func init() {
 		&gentleIO, "gentle-io", false,
 		"enable gentle I/O mode to reduce impact on other applications (uses O_DIRECT, fadvise, throttling)",
 	)
+	rootCmd.PersistentFlags().BoolVar(
+		&withSHA256, "sha256", false,
+		"compute sha256: stored as object metadata on upload, printed by hash",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&limitRate, "limit-rate", "",
+		"cap bandwidth in each direction across all workers, e.g. 50M, or by time of day, e.g. 09:00-18:00=10M,50M",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&limitUploadRate, "limit-upload-rate", "",
+		"cap upload bandwidth, overriding --limit-rate; same format",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&limitDownloadRate, "limit-download-rate", "",
+		"cap download bandwidth, overriding --limit-rate; same format",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&maxReadOps, "max-read-ops", "",
+		"cap get, stat and list requests per second to each bucket, e.g. 500, or 500,logs=50 to set one bucket apart",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&maxWriteOps, "max-write-ops", "",
+		"cap put, copy and delete requests per second to each bucket; same format",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&parallelListing, "parallel-listing", 0,
+		"list a large remote prefix as this many shards at once, split by its sub-prefixes (default sequential)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&configPath, "config", "",
+		"read profiles and bucket routing from this file (default $GSG_CONFIG, then gsg/config.json in the user config dir)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&gcsEndpoint, "gcs-endpoint", "",
+		"send gs:// requests to this JSON API endpoint, e.g. a private service connect one; credentials are optional there (see also STORAGE_EMULATOR_HOST)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&impersonate, "impersonate-service-account", "",
+		"act as this service account on gs://, using the credentials found to get its tokens",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&anonymous, "anonymous", false,
+		"send no credentials, to list and read public buckets; also what happens when none are found",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&billingProject, "billing-project", "",
+		"bill requests to requester pays gs:// buckets to this project",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&requestPayer, "request-payer", false,
+		"agree to pay for requests to requester pays s3:// buckets",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&kmsKey, "kms-key", "",
+		"encrypt objects written with this KMS key: a Cloud KMS key name on gs://, a key id, ARN or alias on s3://",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&encryptionKey, "encryption-key", "",
+		"read and write objects with this base64 AES-256 key (CSEK on gs://, SSE-C on s3://)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&sourceKey, "source-encryption-key", "",
+		"read the sources of cp, mv and rsync with this base64 AES-256 key instead of --encryption-key, or in the clear with \""+noSourceKey+"\"",
+	)
 	rootCmd.PersistentFlags().Bool(
 		"debug", false,
 		"enable debugging mode to print more logs",
@@ -81,6 +169,45 @@ func getMultiThread() int {
 	return multiThread
 }
 
+// newTransferLimiter builds the limiter for one direction from its own flag,
+// or from --limit-rate if that is not given. Each is a single token bucket,
+// so the cap holds however many workers and chunks are running.
+func newTransferLimiter(flag, value string) *common.ScheduledLimiter {
+	if value == "" {
+		flag, value = "limit-rate", limitRate
+	}
+	if value == "" {
+		return nil
+	}
+	schedule, err := common.ParseRateSchedule(value)
+	if err != nil {
+		logger.Info(module, "invalid --%s: %s", flag, err)
+		common.Exit()
+		return nil
+	}
+	return common.NewScheduledLimiter(schedule)
+}
+
+// newRequestBudget parses one of the request rate flags, nil when not given
+func newRequestBudget(flag, value string) *common.BucketRates {
+	if value == "" {
+		return nil
+	}
+	rates, err := common.ParseBucketRates(value)
+	if err != nil {
+		logger.Info(module, "invalid --%s: %s", flag, err)
+		common.Exit()
+		return nil
+	}
+	return rates
+}
+
+// walkTree walks fo with Walk, or with ParallelWalk when --parallel-listing
+// asks for it.
+func walkTree(fo *system.FileObject, isRec bool, fn func(*system.FileObject) error) error {
+	return system.ParallelWalk(fo.System, fo.Bucket, fo.Prefix, isRec, parallelListing, fn)
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "gsg",
 	Short: "A Golang application that lets you access Cloud Storage from the command line.",
@@ -90,14 +217,58 @@ var rootCmd = &cobra.Command{
 - Uploading, downloading, and deleting objects.
 - Listing buckets and objects.
 - Moving, copying, and renaming objects.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// The credential flags go first: profiles in the config file take
+		// them as their defaults.
+		g := system.Lookup("gs").(*gcs.GCS)
+		if gcsEndpoint != "" {
+			g.Endpoint = gcsEndpoint
+		}
+		if impersonate != "" {
+			g.ImpersonateServiceAccount = impersonate
+		}
+		if billingProject != "" {
+			g.BillingProject = billingProject
+		}
+		g.Anonymous = anonymous
+		g.KMSKeyName, g.EncryptionKey = kmsKey, encryptionKey
+		s := system.Lookup("s3").(*s3.S3)
+		s.Anonymous, s.RequestPayer = anonymous, requestPayer
+		s.SSEKMSKeyID, s.SSECustomerKey = kmsKey, encryptionKey
+		loadConfig()
+		uploadLimit = newTransferLimiter("limit-upload-rate", limitUploadRate)
+		downloadLimit = newTransferLimiter("limit-download-rate", limitDownloadRate)
+		reads, writes := newRequestBudget("max-read-ops", maxReadOps), newRequestBudget("max-write-ops", maxWriteOps)
+		if reads != nil || writes != nil {
+			common.Requests = common.NewRequestLimiter(reads, writes)
+		}
+	},
 }
 
 // TODO: replace this with proper cobra method
 func initFlags() {
-	for _, v := range os.Args {
+	for i, v := range os.Args {
 		if v == "-m" {
 			enableMultiThread = true
 		}
+		// The pool is sized before cobra parses anything, so -c has to be
+		// read here too or it is always the default. cobra takes the flag
+		// as --c as well, its long name being c.
+		if (v == "-c" || v == "--c") && i+1 < len(os.Args) {
+			if n, err := strconv.Atoi(os.Args[i+1]); err == nil {
+				multiThread = n
+			}
+		}
+		for _, prefix := range []string{"-c=", "--c="} {
+			if strings.HasPrefix(v, prefix) {
+				if n, err := strconv.Atoi(v[len(prefix):]); err == nil {
+					multiThread = n
+				}
+			}
+		}
+		if v == "--adaptive" {
+			adaptive = true
+		}
 		if v == "--debug" {
 			debugging = true
 			logger.Debugging = true
@@ -112,6 +283,33 @@ func initFlags() {
 	}
 }
 
+// poolDepth is how many levels of nested work the pool has workers for: the
+// deepest nesting is a job per file, or per batch of files or deletes, whose
+// download chunks or copy parts go to its group's Child. Work nested deeper
+// would run inline.
+const poolDepth = 2
+
+const (
+	// adaptiveStart is how many workers --adaptive begins with
+	adaptiveStart = 4
+	// adaptiveInterval is how long each concurrency is measured for: long
+	// enough for a transfer to reach speed, short enough to react to a 429.
+	adaptiveInterval = 2 * time.Second
+)
+
+// startAdaptive lets the pool's concurrency float between one and max,
+// AIMD-style: throttling halves it, busy workers grow it while that raises
+// throughput. Throttling is only seen through the backends' transports, so
+// they are asked to watch for it.
+func startAdaptive(max int) {
+	common.ObserveThrottling = true
+	pool.SetLimit(adaptiveStart)
+	logger.Info(module, "Adaptive concurrency: starting with %d of up to %d workers", adaptiveStart, max)
+	pool.Adapt(&worker.AIMD{Min: 1, Max: max, Hold: 5}, adaptiveInterval, bar.Transferred, common.Throttles, func(n int) {
+		logger.Info(module, "Adaptive concurrency: now %d workers", n)
+	})
+}
+
 // Execute executes the root command.
 func Execute() error {
 	defer common.Recovery()
@@ -124,8 +322,11 @@ func Execute() error {
 	screenCols, screenLines := bars.GetScreenDimensions()
 
 	selectedMultiThread := getMultiThread()
-	pool = worker.New(getMultiThread(), true)
+	pool = worker.NewWithDepth(getMultiThread(), poolDepth, true)
 	pool.Run()
+	if adaptive && selectedMultiThread > adaptiveStart {
+		startAdaptive(selectedMultiThread)
+	}
 
 	logger.Debug(
 		module, "enableMultiThread=%t, mockFail=%t, multiThread=%d, getMultiThread=%d, screenCols=%d, screenLines=%d, gentleIO=%t, chunkSize=%d",
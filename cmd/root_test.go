@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,3 +11,14 @@ func TestMultiThread(t *testing.T) {
 	assert.False(t, enableMultiThread)
 	assert.Equal(t, 64, multiThread)
 }
+
+func TestInitFlagsConcurrency(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args, multiThread = args, 64 }()
+	for _, flags := range [][]string{{"-c", "8"}, {"-c=8"}, {"--c", "8"}, {"--c=8"}} {
+		multiThread = 64
+		os.Args = append([]string{"gsg", "ls"}, flags...)
+		initFlags()
+		assert.Equal(t, 8, multiThread, flags)
+	}
+}
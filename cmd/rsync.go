@@ -1,46 +1,47 @@
 package cmd
 
 import (
+	"context"
+
 	"github.com/nextbillion-ai/gsg/common"
 	"github.com/nextbillion-ai/gsg/linux"
 	"github.com/nextbillion-ai/gsg/logger"
 	"github.com/nextbillion-ai/gsg/system"
+	"github.com/nextbillion-ai/gsg/worker"
 
 	"github.com/spf13/cobra"
 )
 
+// compareSHA256 is set by rsync --checksum
+var compareSHA256 bool
+
 func init() {
 	rsyncCmd.Flags().BoolP("r", "r", false, "rsync an entire directory tree")
 	rsyncCmd.Flags().BoolP("d", "d", false, "delete objects if not exists")
 	rsyncCmd.Flags().BoolP("v", "v", false, "force checksum after command operated, raise error if failed")
+	rsyncCmd.Flags().StringP("L", "L", "", "append a record of every transfer to this csv or jsonl log, and skip those it records as done")
+	rsyncCmd.Flags().BoolVar(&compareSHA256, "checksum", false, "compare files by sha256 where both sides have one, instead of by mtime")
 	rootCmd.AddCommand(rsyncCmd)
 }
-func deleteDst(src, dst *system.FileObject, _, isDel, _ bool) bool {
+func deleteDst(g *worker.Group, src, dst *system.FileObject, _, isDel, _ bool) bool {
 	if src.FileType() == system.FileType_Invalid && isDel {
 		if dst.FileType() == system.FileType_Directory {
-			var err error
-			var fos []*system.FileObject
-			if fos, err = dst.System.List(dst.Bucket, dst.Prefix, true); err != nil {
+			d := newDeleter(g, dst.System, dst.Bucket)
+			if err := walkTree(dst, true, func(fo *system.FileObject) error {
+				d.Add(fo.Prefix)
+				return nil
+			}); err != nil {
 				common.Exit()
 			}
-			for _, fo := range fos {
-				bucket := fo.Bucket
-				prefix := fo.Prefix
-				system := fo.System
-				pool.Add(func() {
-					if e := system.Delete(bucket, prefix); e != nil {
-						common.Exit()
-					}
-				})
-			}
+			d.Flush()
 		}
 		return true
 	}
 	return false
 }
 
-func downsync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
-	if deleteDst(src, dst, isRec, isDel, forceChecksum) {
+func downsync(g *worker.Group, src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
+	if deleteDst(g, src, dst, isRec, isDel, forceChecksum) {
 		logger.Debug(module, "cleaned up dst on non-existing src with -d flag")
 		return
 	}
@@ -54,30 +55,26 @@ func downsync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
 	}
 	logger.Info(module, "Starting synchronization...")
 	for _, fo := range copyList {
-		if e := common.DoWithRetrySimple(func() error {
-			return fo.System.Download(fo.Bucket, fo.Prefix, common.JoinPath(dst.Prefix, fo.Attributes.RelativePath), forceChecksum, system.RunContext{Pool: pool, Bars: bars, ChunkSize: chunkSize, GentleIO: gentleIO})
-		}); e != nil {
-			common.Exit()
-		}
+		dstPath := common.JoinPath(dst.Prefix, fo.Attributes.RelativePath)
+		g.Go(func(context.Context) error {
+			return logTransfer(fo.System, fo.Bucket, fo.Prefix, dst.System, dst.Bucket, dstPath, func() error {
+				return common.DoWithRetrySimple(func() error {
+					return fo.System.Download(fo.Bucket, fo.Prefix, dstPath, forceChecksum, system.RunContext{Group: g, Bars: bars, ChunkSize: chunkSize, GentleIO: gentleIO, DownloadLimit: downloadLimit})
+				})
+			})
+		})
 	}
 	if isDel {
+		d := newDeleter(g, dst.System, dst.Bucket)
 		for _, fo := range deleteList {
-			system := fo.System
-			bucket := fo.Bucket
-			prefix := fo.Prefix
-			pool.Add(func() {
-				if e := common.DoWithRetrySimple(func() error {
-					return system.Delete(bucket, prefix)
-				}); e != nil {
-					common.Exit()
-				}
-			})
+			d.Add(fo.Prefix)
 		}
+		d.Flush()
 	}
 }
 
-func upsync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
-	if deleteDst(src, dst, isRec, isDel, forceChecksum) {
+func upsync(g *worker.Group, src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
+	if deleteDst(g, src, dst, isRec, isDel, forceChecksum) {
 		logger.Debug(module, "cleaned up dst on non-existing src with -d flag")
 		return
 	}
@@ -92,31 +89,25 @@ func upsync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
 	for _, fo := range copyList {
 		from := fo.Prefix
 		dstPath := common.JoinPath(dst.Prefix, fo.Attributes.RelativePath)
-		pool.Add(func() {
-			if e := common.DoWithRetrySimple(func() error {
-				return dst.System.Upload(from, dst.Bucket, dstPath, system.RunContext{Bars: bars})
-			}); e != nil {
-				common.Exit()
-			}
+		g.Go(func(context.Context) error {
+			return logTransfer(fo.System, fo.Bucket, from, dst.System, dst.Bucket, dstPath, func() error {
+				return common.DoWithRetrySimple(func() error {
+					return dst.System.Upload(from, dst.Bucket, dstPath, system.RunContext{Bars: bars, SHA256: withSHA256, UploadLimit: uploadLimit})
+				})
+			})
 		})
 	}
 	if isDel {
+		d := newDeleter(g, dst.System, dst.Bucket)
 		for _, fo := range deleteList {
-			dstPath := common.JoinPath(dst.Prefix, fo.Attributes.RelativePath)
-			system := fo.System
-			pool.Add(func() {
-				if e := common.DoWithRetrySimple(func() error {
-					return system.Delete(dst.Bucket, dstPath)
-				}); e != nil {
-					common.Exit()
-				}
-			})
+			d.Add(common.JoinPath(dst.Prefix, fo.Attributes.RelativePath))
 		}
+		d.Flush()
 	}
 }
 
-func cloudSync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
-	if deleteDst(src, dst, isRec, isDel, forceChecksum) {
+func cloudSync(g *worker.Group, src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
+	if deleteDst(g, src, dst, isRec, isDel, forceChecksum) {
 		logger.Debug(module, "cleaned up dst on non-existing src with -d flag")
 		return
 	}
@@ -139,31 +130,25 @@ func cloudSync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
 		system := fo.System
 		bucket := fo.Bucket
 		prefix := fo.Prefix
-		pool.Add(func() {
-			if e := common.DoWithRetrySimple(func() error {
-				return system.Copy(bucket, prefix, dst.Bucket, dstPath)
-			}); e != nil {
-				common.Exit()
-			}
+		g.Go(func(context.Context) error {
+			return logTransfer(system, bucket, prefix, dst.System, dst.Bucket, dstPath, func() error {
+				return common.DoWithRetrySimple(func() error {
+					return copyObject(g, system, bucket, prefix, dst.Bucket, dstPath)
+				})
+			})
 		})
 	}
 	if isDel {
+		d := newDeleter(g, dst.System, dst.Bucket)
 		for _, fo := range deleteList {
-			dstPath := common.JoinPath(dst.Prefix, fo.Attributes.RelativePath)
-			system := fo.System
-			pool.Add(func() {
-				if e := common.DoWithRetrySimple(func() error {
-					return system.Delete(dst.Bucket, dstPath)
-				}); e != nil {
-					common.Exit()
-				}
-			})
+			d.Add(common.JoinPath(dst.Prefix, fo.Attributes.RelativePath))
 		}
+		d.Flush()
 	}
 }
 
-func localSync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
-	if deleteDst(src, dst, isRec, isDel, forceChecksum) {
+func localSync(g *worker.Group, src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
+	if deleteDst(g, src, dst, isRec, isDel, forceChecksum) {
 		logger.Debug(module, "cleaned up dst on non-existing src with -d flag")
 		return
 	}
@@ -180,31 +165,25 @@ func localSync(src, dst *system.FileObject, isRec, isDel, forceChecksum bool) {
 		system := fo.System
 		bucket := fo.Bucket
 		prefix := fo.Prefix
-		pool.Add(func() {
-			if e := common.DoWithRetrySimple(func() error {
-				return system.Copy(bucket, prefix, dst.Bucket, dstPath)
-			}); e != nil {
-				common.Exit()
-			}
+		g.Go(func(context.Context) error {
+			return logTransfer(system, bucket, prefix, dst.System, dst.Bucket, dstPath, func() error {
+				return common.DoWithRetrySimple(func() error {
+					return system.Copy(bucket, prefix, dst.Bucket, dstPath)
+				})
+			})
 		})
 	}
 	if isDel {
+		d := newDeleter(g, dst.System, dst.Bucket)
 		for _, fo := range deleteList {
-			dstPath := common.JoinPath(dst.Prefix, fo.Attributes.RelativePath)
-			system := fo.System
-			pool.Add(func() {
-				if e := common.DoWithRetrySimple(func() error {
-					return system.Delete(dst.Bucket, dstPath)
-				}); e != nil {
-					common.Exit()
-				}
-			})
+			d.Add(common.JoinPath(dst.Prefix, fo.Attributes.RelativePath))
 		}
+		d.Flush()
 	}
 }
 
 var rsyncCmd = &cobra.Command{
-	Use:   "rsync [-r] [-d] [source url]... [destination url]",
+	Use:   "rsync [-r] [-d] [-L log] [source url]... [destination url]",
 	Short: "Rsync files and objects to destination",
 	Long:  "Rsync files and objects to destination",
 	Args:  cobra.ExactArgs(2),
@@ -212,8 +191,11 @@ var rsyncCmd = &cobra.Command{
 		isRec, _ := cmd.Flags().GetBool("r")
 		isDel, _ := cmd.Flags().GetBool("d")
 		forceChecksum, _ := cmd.Flags().GetBool("v")
+		logPath, _ := cmd.Flags().GetString("L")
+		setupTransferLog(logPath)
 		src := system.ParseFileObject(args[0])
 		dst := system.ParseFileObject(args[1])
+		keySource(src, dst)
 		switch src.FileType() {
 		case system.FileType_Invalid:
 			if !isDel {
@@ -236,7 +218,10 @@ var rsyncCmd = &cobra.Command{
 		}
 
 		logger.Info(module, "Building synchronization state...")
-		if src.Remote && dst.Remote {
+		// The first transfer or delete to fail stops the rest.
+		g := pool.Group(context.Background()).FailFast()
+		switch {
+		case src.Remote && dst.Remote:
 			if src.System.Scheme() != dst.System.Scheme() {
 				logger.Info(
 					module,
@@ -244,26 +229,29 @@ var rsyncCmd = &cobra.Command{
 					src.System.Scheme(), dst.System.Scheme(),
 				)
 				common.Exit()
+				return
 			}
-			cloudSync(src, dst, isRec, isDel, forceChecksum)
-			return
-		}
-		if src.Remote {
-			downsync(src, dst, isRec, isDel, forceChecksum)
-			return
+			cloudSync(g, src, dst, isRec, isDel, forceChecksum)
+		case src.Remote:
+			downsync(g, src, dst, isRec, isDel, forceChecksum)
+		case dst.Remote:
+			upsync(g, src, dst, isRec, isDel, forceChecksum)
+		default:
+			localSync(g, src, dst, isRec, isDel, forceChecksum)
 		}
-		if dst.Remote {
-			upsync(src, dst, isRec, isDel, forceChecksum)
-			return
+		if err := g.Wait(); err != nil {
+			logger.Info(module, "rsync failed with %s", err)
+			common.Exit()
 		}
-		localSync(src, dst, isRec, isDel, forceChecksum)
 	},
 }
 
 func listRelatively(base *system.FileObject, isRec bool) map[string]*system.FileObject {
-	var err error
-	var fos []*system.FileObject
-	if fos, err = base.System.List(base.Bucket, base.Prefix, isRec); err != nil {
+	fos := []*system.FileObject{}
+	if err := walkTree(base, isRec, func(fo *system.FileObject) error {
+		fos = append(fos, fo)
+		return nil
+	}); err != nil {
 		common.Exit()
 	}
 	r := map[string]*system.FileObject{}
@@ -293,7 +281,7 @@ func listRelatively(base *system.FileObject, isRec bool) map[string]*system.File
 func diffs(srcFiles, dstFiles map[string]*system.FileObject, forceChecksum bool) (copyList, deleteList []*system.FileObject) {
 	for rp, sf := range srcFiles {
 		df, ok := dstFiles[rp]
-		if ok && sf.Attributes != nil && sf.Attributes.Same(df.Attributes, forceChecksum) {
+		if ok && sf.Attributes != nil && sameFile(sf.Attributes, df.Attributes, forceChecksum) {
 			continue
 		}
 		copyList = append(copyList, sf)
@@ -319,6 +307,17 @@ func diffs(srcFiles, dstFiles map[string]*system.FileObject, forceChecksum bool)
 	return
 }
 
+// sameFile compares by sha256 under --checksum when both sides know theirs,
+// and otherwise the way rsync always has.
+func sameFile(a, b *system.Attrs, forceChecksum bool) bool {
+	if compareSHA256 {
+		if same, ok := a.SameSHA256(b); ok {
+			return same
+		}
+	}
+	return a.Same(b, forceChecksum)
+}
+
 func deleteTempFiles(dir string, isRec bool) {
 	l := system.Lookup("")
 	for _, obj := range linux.ListTempFiles(dir, isRec) {
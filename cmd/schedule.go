@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nextbillion-ai/gsg/system"
+	"github.com/nextbillion-ai/gsg/worker"
+
+	"google.golang.org/api/googleapi"
+)
+
+// The orders cp -r can submit the files of a tree to the pool in
+const (
+	// scheduleListing submits files in listing order, one job each
+	scheduleListing = "listing"
+	// scheduleLargestFirst starts the largest files first, so that the run
+	// does not end with one large file transferring while the rest idle
+	scheduleLargestFirst = "largest-first"
+	// scheduleBalanced is largest-first, with tiny files batched into shared
+	// jobs and large downloads split so that every worker takes a chunk
+	scheduleBalanced = "balanced"
+)
+
+const (
+	// tinyFile is the size below which balanced batches files together
+	tinyFile = 256 << 10
+	// tinyBatchFiles and tinyBatchBytes bound one batch of tiny files
+	tinyBatchFiles = 64
+	tinyBatchBytes = 4 << 20
+	// minSplitChunk is the smallest chunk balanced splits a download into:
+	// below it, a range request costs more than it parallelizes.
+	minSplitChunk = 4 << 20
+)
+
+// scheduleStrategy is set by cp --schedule; every other command keeps the
+// listing order.
+var scheduleStrategy = scheduleListing
+
+func parseSchedule(s string) (string, error) {
+	switch s {
+	case scheduleListing, scheduleLargestFirst, scheduleBalanced:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown schedule %q, want %s, %s or %s", s, scheduleListing, scheduleLargestFirst, scheduleBalanced)
+}
+
+// transfer is one file of a tree waiting to be submitted
+type transfer struct {
+	size int64
+	run  func() error
+}
+
+func objectSize(fo *system.FileObject) int64 {
+	if fo.Attributes == nil {
+		return 0
+	}
+	return fo.Attributes.Size
+}
+
+// planTransfers orders the transfers of a tree for strategy and groups them
+// into pool jobs, each of which runs its transfers one after another.
+func planTransfers(strategy string, ts []transfer) [][]transfer {
+	if strategy != scheduleListing {
+		sort.SliceStable(ts, func(i, j int) bool { return ts[i].size > ts[j].size })
+	}
+	jobs := make([][]transfer, 0, len(ts))
+	var tiny []transfer
+	var tinyBytes int64
+	for _, t := range ts {
+		if strategy != scheduleBalanced || t.size >= tinyFile {
+			jobs = append(jobs, []transfer{t})
+			continue
+		}
+		tiny = append(tiny, t)
+		tinyBytes += t.size
+		if len(tiny) == tinyBatchFiles || tinyBytes >= tinyBatchBytes {
+			jobs = append(jobs, tiny)
+			tiny, tinyBytes = nil, 0
+		}
+	}
+	if len(tiny) > 0 {
+		jobs = append(jobs, tiny)
+	}
+	return jobs
+}
+
+// submitTransfers queues the transfers of a tree as jobs of g, in the order
+// of the chosen schedule. A job of several stops at the first that fails, or
+// once g is cancelled.
+func submitTransfers(ts []transfer, g *worker.Group) {
+	for _, job := range planTransfers(scheduleStrategy, ts) {
+		g.Go(func(ctx context.Context) error {
+			for _, t := range job {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := t.run(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// walkTransfers submits a transfer, made by plan, for every file of the tree
+// at src. In listing order each is submitted as it is listed, so the first
+// starts with the first page and the listing never has to be held whole;
+// the other schedules have to see all of it before they can order it.
+func walkTransfers(src *system.FileObject, isRec bool, g *worker.Group, plan func(obj *system.FileObject) transfer) error {
+	if scheduleStrategy == scheduleListing {
+		return walkTree(src, isRec, func(obj *system.FileObject) error {
+			submitTransfers([]transfer{plan(obj)}, g)
+			return nil
+		})
+	}
+	ts := []transfer{}
+	if err := walkTree(src, isRec, func(obj *system.FileObject) error {
+		ts = append(ts, plan(obj))
+		return nil
+	}); err != nil {
+		return err
+	}
+	submitTransfers(ts, g)
+	return nil
+}
+
+// chunkSizeFor is the download chunk size for an object of size. With the
+// balanced schedule, an object too small to give every worker a chunk at the
+// usual size is split into smaller ones, down to minSplitChunk. Chunking
+// turned off with --chunk-size 0 stays off.
+func chunkSizeFor(size int64) int64 {
+	if scheduleStrategy != scheduleBalanced || chunkSize == 0 {
+		return chunkSize
+	}
+	base := chunkSize
+	if base < 0 {
+		base = googleapi.DefaultUploadChunkSize
+	}
+	workers := int64(pool.Limit())
+	if size/base >= workers {
+		return chunkSize
+	}
+	split := size / workers
+	if split < minSplitChunk {
+		split = minSplitChunk
+	}
+	// Whole MiBs keep chunks aligned for --gentle-io.
+	split = (split + 1<<20 - 1) &^ (1<<20 - 1)
+	if split >= base {
+		return chunkSize
+	}
+	return split
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanTransfers(t *testing.T) {
+	sizes := func(jobs [][]transfer) [][]int64 {
+		out := [][]int64{}
+		for _, job := range jobs {
+			s := []int64{}
+			for _, tr := range job {
+				s = append(s, tr.size)
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	ts := func() []transfer {
+		return []transfer{{size: 10}, {size: 1 << 30}, {size: 20}, {size: 1 << 20}}
+	}
+	assert.Equal(t, [][]int64{{10}, {1 << 30}, {20}, {1 << 20}}, sizes(planTransfers(scheduleListing, ts())))
+	assert.Equal(t, [][]int64{{1 << 30}, {1 << 20}, {20}, {10}}, sizes(planTransfers(scheduleLargestFirst, ts())))
+	assert.Equal(t, [][]int64{{1 << 30}, {1 << 20}, {20, 10}}, sizes(planTransfers(scheduleBalanced, ts())))
+
+	many := make([]transfer, tinyBatchFiles+1)
+	assert.Len(t, planTransfers(scheduleBalanced, many), 2)
+}
+
+func TestChunkSizeFor(t *testing.T) {
+	defer func(p *worker.Pool, c int64, s string) { pool, chunkSize, scheduleStrategy = p, c, s }(pool, chunkSize, scheduleStrategy)
+	pool = worker.New(8, false)
+	chunkSize = -1
+
+	scheduleStrategy = scheduleListing
+	assert.Equal(t, int64(-1), chunkSizeFor(100<<20))
+
+	scheduleStrategy = scheduleBalanced
+	// 100MiB over 8 workers: 12.5MiB, rounded up to whole MiBs.
+	assert.Equal(t, int64(13<<20), chunkSizeFor(100<<20))
+	assert.Equal(t, int64(minSplitChunk), chunkSizeFor(10<<20))
+	// Big enough to give every worker a 16MiB chunk already.
+	assert.Equal(t, int64(-1), chunkSizeFor(1<<30))
+	chunkSize = 0
+	assert.Equal(t, int64(0), chunkSizeFor(100<<20))
+
+	_, err := parseSchedule("fastest")
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	scrubCmd.Flags().Float64("sample", 100, "percentage of files to re-read, chosen at random each run")
+	scrubCmd.Flags().String("bandwidth", "", "cap on bytes read per second across all workers, e.g. 50M")
+	scrubCmd.Flags().String("checkpoint", "", "file to resume from and record progress in, removed once a pass completes")
+	scrubCmd.Flags().String("report", "", "append failures as json lines to this file instead of printing them")
+	rootCmd.AddCommand(scrubCmd)
+}
+
+const (
+	scrubOK           = "ok"
+	scrubCorrupt      = "corrupt"
+	scrubError        = "error"
+	scrubUnverifiable = "unverifiable"
+	scrubRecorded     = "recorded"
+	// scrubCheckpointEvery is how many completed files pass between checkpoint
+	// writes: often enough that a killed run loses little, rarely enough that
+	// the atomic write is not what a scrub spends its time on.
+	scrubCheckpointEvery = 100
+)
+
+type scrubResult struct {
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Time     string `json:"time"`
+}
+
+// scrubFile re-reads one file or object in full and compares its crc32c with
+// the one on record. For an object that is the crc32c its backend stored; a
+// local file has only gsg's own crc32c cache, so a file scrubbed for the first
+// time has its checksum recorded there instead, and verified from then on.
+func scrubFile(fo *system.FileObject, limiter *rate.Limiter) *scrubResult {
+	r := &scrubResult{Path: fo.Prefix, Time: time.Now().UTC().Format(time.RFC3339)}
+	if fo.Remote {
+		r.Path = fo.GetFullPath()
+	}
+	attrs := fo.Attributes
+	var expected uint32
+	if fo.Remote {
+		// An s3 object uploaded without a checksum reads back as 0, as does
+		// every empty object, which genuinely has crc32c 0.
+		if attrs.GetCRC32C() == 0 && attrs.Size > 0 {
+			r.Status = scrubUnverifiable
+			r.Error = "no stored crc32c"
+			return r
+		}
+		expected = attrs.CRC32
+	} else {
+		var ok bool
+		if expected, ok = common.CachedFileCRC32C(fo.Prefix); !ok {
+			r.Status = scrubRecorded
+			r.Actual = fmt.Sprintf("%08x", common.GetFileCRC32C(fo.Prefix))
+			return r
+		}
+	}
+	r.Expected = fmt.Sprintf("%08x", expected)
+
+	reader, ok := fo.System.(system.Reader)
+	if !ok {
+		r.Status = scrubError
+		r.Error = fmt.Sprintf("%s backend cannot stream objects", fo.System.Scheme())
+		return r
+	}
+	var actual uint32
+	err := common.DoWithRetrySimple(func() error {
+		rc, err := reader.GetObjectReader(fo.Bucket, fo.Prefix)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rc.Close() }()
+		actual, err = common.ComputeCRC32C(common.NewRateLimitedReader(rc, limiter))
+		return err
+	})
+	if err != nil {
+		r.Status = scrubError
+		r.Error = err.Error()
+		return r
+	}
+	r.Actual = fmt.Sprintf("%08x", actual)
+	r.Status = scrubOK
+	if actual != expected {
+		r.Status = scrubCorrupt
+	}
+	return r
+}
+
+// scrubProgress tracks which files have completed, out of order, and the
+// longest completed run from the start: everything before the watermark is
+// done, so that is where a resumed run can start.
+type scrubProgress struct {
+	mu        sync.Mutex
+	done      []bool
+	watermark int
+	completed int
+}
+
+func newScrubProgress(n int) *scrubProgress {
+	return &scrubProgress{done: make([]bool, n)}
+}
+
+// complete marks index done and returns the watermark, and whether it is time
+// to write a checkpoint.
+func (p *scrubProgress) complete(index int) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[index] = true
+	p.completed++
+	for p.watermark < len(p.done) && p.done[p.watermark] {
+		p.watermark++
+	}
+	return p.watermark, p.completed%scrubCheckpointEvery == 0
+}
+
+func readScrubCheckpoint(path string) string {
+	if path == "" {
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(b), "\n")
+}
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub [--sample pct] [--bandwidth rate] [--checkpoint file] [--report file] [url]",
+	Short: "Re-read stored data and verify its checksums",
+	Long: `Re-read every file or object under a url, recompute its crc32c and compare it
+with the stored one, to find silent corruption.
+
+Objects are checked against the crc32c their backend stored. Local files have
+none, so the first scrub records one in gsg's crc32c cache and later scrubs
+verify against it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sample, _ := cmd.Flags().GetFloat64("sample")
+		bandwidth, _ := cmd.Flags().GetString("bandwidth")
+		checkpoint, _ := cmd.Flags().GetString("checkpoint")
+		report, _ := cmd.Flags().GetString("report")
+
+		var limiter *rate.Limiter
+		if bandwidth != "" {
+			bps, err := common.ParseByteRate(bandwidth)
+			if err != nil {
+				logger.Info(module, "%s", err)
+				common.Exit()
+				return
+			}
+			limiter = common.NewByteLimiter(bps)
+		}
+
+		base := system.ParseFileObject(args[0])
+		files := map[string]*system.FileObject{}
+		switch base.FileType() {
+		case system.FileType_Object:
+			_, name := common.ParseFile(base.Prefix)
+			files[name] = base
+		case system.FileType_Directory:
+			files = listRelatively(base, true)
+		default:
+			logger.Info(module, "Invalid bucket[%s] with prefix[%s]", base.Bucket, base.Prefix)
+			common.Exit()
+			return
+		}
+
+		// Sorted, so that a checkpoint naming one path means everything
+		// before it, regardless of the order a backend lists in.
+		resumeAfter := readScrubCheckpoint(checkpoint)
+		paths := make([]string, 0, len(files))
+		for rp := range files {
+			if rp > resumeAfter && rand.Float64()*100 < sample {
+				paths = append(paths, rp)
+			}
+		}
+		sort.Strings(paths)
+		if resumeAfter != "" {
+			logger.Info(module, "Resuming after [%s] from checkpoint %s", resumeAfter, checkpoint)
+		}
+
+		var out *os.File
+		if report != "" {
+			var err error
+			if out, err = os.OpenFile(report, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+				logger.Info(module, "open report failed with %s", err)
+				common.Exit()
+				return
+			}
+			defer func() { _ = out.Close() }()
+		}
+
+		progress := newScrubProgress(len(paths))
+		counts := map[string]int{}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		saveCheckpoint := func(watermark int) {
+			if checkpoint == "" || watermark == 0 {
+				return
+			}
+			if err := common.WriteFileAtomic(checkpoint, []byte(paths[watermark-1]+"\n"), 0644); err != nil {
+				logger.Info(module, "write checkpoint failed with %s", err)
+			}
+		}
+		for i, rp := range paths {
+			fo := files[rp]
+			wg.Add(1)
+			pool.Add(func() {
+				defer wg.Done()
+				r := scrubFile(fo, limiter)
+				mu.Lock()
+				counts[r.Status]++
+				if r.Status == scrubCorrupt || r.Status == scrubError {
+					b, _ := json.Marshal(r)
+					if out != nil {
+						_, _ = out.Write(append(b, '\n'))
+					} else {
+						logger.Output(string(b) + "\n")
+					}
+				}
+				watermark, save := progress.complete(i)
+				if save {
+					saveCheckpoint(watermark)
+				}
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+
+		// A completed pass starts the next run from the beginning.
+		if checkpoint != "" {
+			_ = os.Remove(checkpoint)
+		}
+		logger.Info(module, "Scrubbed %d file(s): %d ok, %d corrupt, %d error, %d unverifiable, %d recorded",
+			len(paths), counts[scrubOK], counts[scrubCorrupt], counts[scrubError], counts[scrubUnverifiable], counts[scrubRecorded])
+		if counts[scrubCorrupt]+counts[scrubError] > 0 {
+			common.Exit()
+		}
+	},
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/s3"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubProgress(t *testing.T) {
+	p := newScrubProgress(4)
+	w, _ := p.complete(1)
+	assert.Equal(t, 0, w, "index 0 is still running")
+	w, _ = p.complete(0)
+	assert.Equal(t, 2, w)
+	w, _ = p.complete(3)
+	assert.Equal(t, 2, w)
+	w, _ = p.complete(2)
+	assert.Equal(t, 4, w)
+}
+
+// Silent corruption is exactly a change in content that leaves the mtime
+// alone, so that is what this simulates.
+func TestScrubFileLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archived")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	mtime := common.GetFileModificationTime(path)
+	t.Cleanup(func() { _ = os.Remove(common.GenTempFileName(path, "-", mtime.String(), "-crc32c")) })
+	fo := system.ParseFileObject(path)
+	assert.Equal(t, system.FileType_Object, fo.FileType())
+
+	assert.Equal(t, scrubRecorded, scrubFile(fo, nil).Status)
+	assert.Equal(t, scrubOK, scrubFile(fo, nil).Status)
+
+	assert.NoError(t, os.WriteFile(path, []byte("jello"), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), mtime))
+	r := scrubFile(fo, common.NewByteLimiter(1024))
+	assert.Equal(t, scrubCorrupt, r.Status)
+	assert.Equal(t, "9a71bb4c", r.Expected)
+}
+
+// S3 reports crc32c as the base64 of its four big-endian bytes.
+func TestScrubFileS3(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	body := "hello world"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["attributes"]; ok {
+			_, _ = fmt.Fprintf(w, `<GetObjectAttributesResponse><ObjectSize>%d</ObjectSize>`+
+				`<Checksum><ChecksumCRC32C>yZRlqg==</ChecksumCRC32C></Checksum></GetObjectAttributesResponse>`, len(body))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	s := s3.New("s3", &s3.Profile{Endpoint: s3.Endpoint{URL: srv.URL, PathStyle: true}})
+	attrs, err := s.Attributes("archive", "hello.txt")
+	assert.NoError(t, err)
+	fo := &system.FileObject{System: s, Bucket: "archive", Prefix: "hello.txt", Remote: true, Attributes: attrs}
+
+	r := scrubFile(fo, nil)
+	assert.Equal(t, scrubOK, r.Status, r.Error)
+	assert.Equal(t, "c99465aa", r.Expected)
+	assert.Equal(t, "c99465aa", r.Actual)
+
+	body = "jello world"
+	assert.Equal(t, scrubCorrupt, scrubFile(fo, nil).Status)
+}
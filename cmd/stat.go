@@ -7,8 +7,12 @@ import (
 	"github.com/nextbillion-ai/gsg/common"
 	"github.com/nextbillion-ai/gsg/gcs"
 	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/s3"
 	"github.com/nextbillion-ai/gsg/system"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/spf13/cobra"
 )
 
@@ -27,23 +31,72 @@ var statCmd = &cobra.Command{
 			logger.Info(module, "Invalid bucket[%s] with prefix[%s]", fo.Bucket, fo.Prefix)
 			common.Exit()
 		}
-		if fo.System.Scheme() != "gs" {
-			logger.Info(module, "only gcs is supported")
+		switch sys := fo.System.(type) {
+		case *gcs.GCS:
+			statGCS(sys, fo)
+		case *s3.S3:
+			statS3(sys, fo)
+		default:
+			logger.Info(module, "only gcs and s3 are supported")
 			common.Exit()
 		}
-		g := fo.System.(*gcs.GCS)
-		var err error
-		var attrs *storage.ObjectAttrs
-		if attrs, err = g.GCSAttrs(fo.Bucket, fo.Prefix); err != nil {
-			common.Exit()
-		}
-		logger.Info(module, "%s://%s/%s:", fo.System.Scheme(), fo.Bucket, fo.Prefix)
-		logger.Info(module, "\t%-s:\t%s", "Creation time", attrs.Created)
-		logger.Info(module, "\t%-s:\t%s", "Update time", attrs.Updated)
-		logger.Info(module, "\t%-s:\t%s", "Update time (metadata)", gcs.ParseFileModificationTimeMetadata(attrs))
-		logger.Info(module, "\t%-s:\t%d", "Content-Length", attrs.Size)
-		logger.Info(module, "\t%-s:\t%s", "Content-Type", attrs.ContentType)
-		logger.Info(module, "\t%-s:\t%d", "Hash (crc32c)", attrs.CRC32C)
-		logger.Info(module, "\t%-s:\t%s", "Hash (md5)", base64.StdEncoding.EncodeToString(attrs.MD5))
 	},
 }
+
+func statGCS(g *gcs.GCS, fo *system.FileObject) {
+	var err error
+	var attrs *storage.ObjectAttrs
+	if attrs, err = g.GCSAttrs(fo.Bucket, fo.Prefix); err != nil {
+		common.Exit()
+	}
+	logger.Info(module, "%s://%s/%s:", fo.System.Scheme(), fo.Bucket, fo.Prefix)
+	logger.Info(module, "\t%-s:\t%s", "Creation time", attrs.Created)
+	logger.Info(module, "\t%-s:\t%s", "Update time", attrs.Updated)
+	logger.Info(module, "\t%-s:\t%s", "Update time (metadata)", gcs.ParseFileModificationTimeMetadata(attrs))
+	logger.Info(module, "\t%-s:\t%d", "Content-Length", attrs.Size)
+	logger.Info(module, "\t%-s:\t%s", "Content-Type", attrs.ContentType)
+	logger.Info(module, "\t%-s:\t%d", "Hash (crc32c)", attrs.CRC32C)
+	logger.Info(module, "\t%-s:\t%s", "Hash (md5)", base64.StdEncoding.EncodeToString(attrs.MD5))
+	logger.Info(module, "\t%-s:\t%s", "Encryption", gcsEncryption(attrs))
+}
+
+func statS3(s *s3.S3, fo *system.FileObject) {
+	head, err := s.S3Head(fo.Bucket, fo.Prefix)
+	if err != nil {
+		logger.Info(module, "stat s3 object failed with %s", err)
+		common.Exit()
+	}
+	logger.Info(module, "%s://%s/%s:", fo.System.Scheme(), fo.Bucket, fo.Prefix)
+	logger.Info(module, "\t%-s:\t%s", "Update time", aws.ToTime(head.LastModified))
+	logger.Info(module, "\t%-s:\t%d", "Content-Length", aws.ToInt64(head.ContentLength))
+	logger.Info(module, "\t%-s:\t%s", "Content-Type", aws.ToString(head.ContentType))
+	logger.Info(module, "\t%-s:\t%s", "ETag", aws.ToString(head.ETag))
+	logger.Info(module, "\t%-s:\t%s", "Storage class", head.StorageClass)
+	logger.Info(module, "\t%-s:\t%s", "Encryption", s3Encryption(head))
+}
+
+// gcsEncryption says which key protects an object: a KMS key by name, a
+// customer-supplied one by its sha256, or else Google's own.
+func gcsEncryption(attrs *storage.ObjectAttrs) string {
+	switch {
+	case attrs.KMSKeyName != "":
+		return "CMEK " + attrs.KMSKeyName
+	case attrs.CustomerKeySHA256 != "":
+		return "CSEK (sha256 " + attrs.CustomerKeySHA256 + ")"
+	}
+	return "Google-managed"
+}
+
+// s3Encryption says which key protects an object: a KMS key by id, a
+// customer-supplied one by its md5, or else S3's own.
+func s3Encryption(head *awss3.HeadObjectOutput) string {
+	switch {
+	case head.SSECustomerKeyMD5 != nil:
+		return "SSE-C (md5 " + aws.ToString(head.SSECustomerKeyMD5) + ")"
+	case head.ServerSideEncryption == types.ServerSideEncryptionAwsKms || head.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse:
+		return string(head.ServerSideEncryption) + " " + aws.ToString(head.SSEKMSKeyId)
+	case head.ServerSideEncryption != "":
+		return string(head.ServerSideEncryption)
+	}
+	return "none"
+}
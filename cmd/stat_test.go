@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSEncryption(t *testing.T) {
+	assert.Equal(t, "Google-managed", gcsEncryption(&storage.ObjectAttrs{}))
+	assert.Equal(t, "CMEK projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+		gcsEncryption(&storage.ObjectAttrs{KMSKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"}))
+	assert.Equal(t, "CSEK (sha256 abc=)", gcsEncryption(&storage.ObjectAttrs{CustomerKeySHA256: "abc="}))
+}
+
+func TestS3Encryption(t *testing.T) {
+	assert.Equal(t, "none", s3Encryption(&awss3.HeadObjectOutput{}))
+	assert.Equal(t, "AES256", s3Encryption(&awss3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAes256}))
+	assert.Equal(t, "aws:kms arn:aws:kms:us-east-1:1:key/k", s3Encryption(&awss3.HeadObjectOutput{
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String("arn:aws:kms:us-east-1:1:key/k"),
+	}))
+	assert.Equal(t, "SSE-C (md5 abc=)", s3Encryption(&awss3.HeadObjectOutput{
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		SSECustomerKeyMD5:    aws.String("abc="),
+	}))
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+)
+
+const (
+	transferOK   = "OK"
+	transferFail = "FAIL"
+)
+
+var transferLogCSVHeader = []string{"source", "destination", "start", "end", "bytes", "md5", "crc32c", "result", "error"}
+
+// transferRecord is one line of a -L log, written once per transfer
+type transferRecord struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Bytes       int64  `json:"bytes"`
+	MD5         string `json:"md5,omitempty"`
+	CRC32C      string `json:"crc32c,omitempty"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (r *transferRecord) csv() []string {
+	return []string{r.Source, r.Destination, r.Start, r.End, strconv.FormatInt(r.Bytes, 10), r.MD5, r.CRC32C, r.Result, r.Error}
+}
+
+// transferLog is the audit log cp and rsync append to with -L, like gsutil cp
+// -L. It also makes a batch copy restartable: a transfer the log already
+// records as successful is skipped when the same log is given again.
+type transferLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	format string
+	done   map[string]bool
+}
+
+// tlog is the log given with -L, or nil. Every method is safe on nil, so
+// transfer sites do not need to check.
+var tlog *transferLog
+
+func transferKey(src, dst string) string {
+	return src + "\x00" + dst
+}
+
+// openTransferLog opens path for appending, after reading which transfers it
+// already records as successful. Each line is written with one unbuffered
+// write, since pool jobs may still be running when a command's Run returns.
+func openTransferLog(path string) (*transferLog, error) {
+	format, _ := manifestFormat("", path)
+	l := &transferLog{format: format, done: map[string]bool{}}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	records, err := parseTransferLog(bytes.NewReader(existing), format)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume from %s: %w", path, err)
+	}
+	for _, r := range records {
+		if r.Result == transferOK {
+			l.done[transferKey(r.Source, r.Destination)] = true
+		}
+	}
+	if l.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	if format == manifestCSV && len(existing) == 0 {
+		l.writeCSV(transferLogCSVHeader)
+	}
+	logger.Debug(module, "transfer log %s records %d successful transfer(s)", path, len(l.done))
+	return l, nil
+}
+
+func parseTransferLog(r io.Reader, format string) ([]*transferRecord, error) {
+	records := []*transferRecord{}
+	if format == manifestCSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(transferLogCSVHeader)
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if i == 0 && row[0] == transferLogCSVHeader[0] {
+				continue
+			}
+			n, _ := strconv.ParseInt(row[4], 10, 64)
+			records = append(records, &transferRecord{
+				Source: row[0], Destination: row[1], Start: row[2], End: row[3], Bytes: n,
+				MD5: row[5], CRC32C: row[6], Result: row[7], Error: row[8],
+			})
+		}
+		return records, nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		rec := &transferRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func (l *transferLog) writeCSV(row []string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(row)
+	w.Flush()
+	_, _ = l.f.Write(buf.Bytes())
+}
+
+// Done reports whether the log records src to dst as already successful
+func (l *transferLog) Done(src, dst string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.done[transferKey(src, dst)]
+}
+
+// Record appends one record to the log
+func (l *transferLog) Record(r *transferRecord) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.format == manifestCSV {
+		l.writeCSV(r.csv())
+	} else {
+		b, _ := json.Marshal(r)
+		_, _ = l.f.Write(append(b, '\n'))
+	}
+	if r.Result == transferOK {
+		l.done[transferKey(r.Source, r.Destination)] = true
+	}
+}
+
+// urlOf names a file or object the way it is given on the command line
+func urlOf(sys system.ISystem, bucket, prefix string) string {
+	if sys.Scheme() == "" {
+		return prefix
+	}
+	return fmt.Sprintf("%s://%s/%s", sys.Scheme(), bucket, prefix)
+}
+
+// logTransfer runs one transfer and records it when -L is given, or skips it
+// when the log already records it as successful.
+func logTransfer(srcSys system.ISystem, srcBucket, srcPrefix string, dstSys system.ISystem, dstBucket, dstPrefix string, transfer func() error) error {
+	if tlog == nil {
+		return transfer()
+	}
+	src, dst := urlOf(srcSys, srcBucket, srcPrefix), urlOf(dstSys, dstBucket, dstPrefix)
+	if tlog.Done(src, dst) {
+		logger.Debug(module, "skipping %s -> %s: already recorded as successful", src, dst)
+		return nil
+	}
+	start := time.Now()
+	err := transfer()
+	recordTransfer(src, dstSys, dstBucket, dstPrefix, start, err)
+	return err
+}
+
+// recordTransfer appends the outcome of a transfer from src that started at
+// start. Size and checksums are those of the destination once written, which
+// is what an audit wants; a checksum that is not known is left empty.
+func recordTransfer(src string, dstSys system.ISystem, dstBucket, dstPrefix string, start time.Time, err error) {
+	if tlog == nil {
+		return
+	}
+	r := &transferRecord{
+		Source:      src,
+		Destination: urlOf(dstSys, dstBucket, dstPrefix),
+		Start:       start.UTC().Format(time.RFC3339Nano),
+		End:         time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err != nil {
+		r.Result = transferFail
+		r.Error = err.Error()
+		tlog.Record(r)
+		return
+	}
+	r.Result = transferOK
+	if attrs, _ := dstSys.Attributes(dstBucket, dstPrefix); attrs != nil {
+		r.Bytes = attrs.Size
+		crc, known := attrs.CRC32, attrs.CRC32 != 0 || attrs.Size == 0
+		if dstSys.Scheme() == "" {
+			// Only what the transfer already computed: re-reading every
+			// file it wrote would double the cost of a logged copy.
+			crc, known = common.CachedFileCRC32C(dstPrefix)
+		}
+		if known {
+			r.CRC32C = fmt.Sprintf("%08x", crc)
+		}
+		if attrs.MD5 != nil {
+			r.MD5 = hex.EncodeToString(attrs.MD5)
+		}
+	}
+	tlog.Record(r)
+}
+
+// setupTransferLog opens the -L log, if one was given, for the rest of the run
+func setupTransferLog(path string) {
+	if path == "" {
+		return
+	}
+	var err error
+	if tlog, err = openTransferLog(path); err != nil {
+		logger.Info(module, "open transfer log failed with %s", err)
+		common.Exit()
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferLogResume(t *testing.T) {
+	for _, name := range []string{"transfers.jsonl", "transfers.csv"} {
+		path := filepath.Join(t.TempDir(), name)
+		l, err := openTransferLog(path)
+		assert.NoError(t, err, name)
+		l.Record(&transferRecord{Source: "a", Destination: "gs://b/a", Bytes: 5, CRC32C: "9a71bb4c", Result: transferOK})
+		// A comma and a quote in an error must survive csv.
+		l.Record(&transferRecord{Source: "c", Destination: "gs://b/c", Result: transferFail, Error: `denied, "c"`})
+		assert.True(t, l.Done("a", "gs://b/a"), name)
+		assert.NoError(t, l.f.Close())
+
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		format, _ := manifestFormat("", path)
+		records, err := parseTransferLog(bytes.NewReader(b), format)
+		assert.NoError(t, err, name)
+		assert.Len(t, records, 2, name)
+		assert.Equal(t, `denied, "c"`, records[1].Error, name)
+
+		// Reopened, only the successful transfer is skipped.
+		l, err = openTransferLog(path)
+		assert.NoError(t, err, name)
+		assert.True(t, l.Done("a", "gs://b/a"), name)
+		assert.False(t, l.Done("c", "gs://b/c"), name)
+		assert.False(t, l.Done("a", "gs://b/other"), name)
+		assert.NoError(t, l.f.Close())
+	}
+}
+
+func TestTransferLogNil(t *testing.T) {
+	var l *transferLog
+	assert.False(t, l.Done("a", "b"))
+	l.Record(&transferRecord{Result: transferOK})
+}
+
+// A local destination is not re-read for the log: its crc32c is recorded only
+// once the transfer has computed and cached it.
+func TestRecordTransferChecksums(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	t.Cleanup(func() {
+		_ = os.Remove(common.GenTempFileName(path, "-", common.GetFileModificationTime(path).String(), "-crc32c"))
+	})
+	var err error
+	saved := tlog
+	t.Cleanup(func() { tlog = saved })
+	tlog, err = openTransferLog(filepath.Join(dir, "transfers.jsonl"))
+	assert.NoError(t, err)
+	local := system.ParseFileObject(path).System
+
+	recordTransfer("gs://b/a.txt", local, "", path, time.Now(), nil)
+	common.GetFileCRC32C(path)
+	recordTransfer("gs://b/a.txt", local, "", path, time.Now(), nil)
+	assert.NoError(t, tlog.f.Close())
+
+	b, err := os.ReadFile(filepath.Join(dir, "transfers.jsonl"))
+	assert.NoError(t, err)
+	records, err := parseTransferLog(bytes.NewReader(b), manifestJSONL)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, int64(5), records[0].Bytes)
+	assert.Equal(t, "", records[0].CRC32C)
+	assert.Equal(t, "9a71bb4c", records[1].CRC32C)
+	assert.Equal(t, "", records[1].MD5)
+}
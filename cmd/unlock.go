@@ -28,16 +28,16 @@ var unlockCmd = &cobra.Command{
 			common.Exit()
 		}
 
-		if fo.System.Scheme() == "gs" {
-			gcs := fo.System.(*gcs.GCS)
+		// Every GCS profile has a scheme of its own.
+		if gcs, ok := fo.System.(*gcs.GCS); ok {
 			if e := gcs.AttemptUnLock(fo.Bucket, fo.Prefix); e != nil {
 				common.Exit()
 			}
 			common.Finish()
 		}
 
-		if fo.System.Scheme() == "s3" {
-			gcs := fo.System.(*s3.S3)
+		// Every S3-compatible store and profile has a scheme of its own.
+		if gcs, ok := fo.System.(*s3.S3); ok {
 			if e := gcs.AttemptUnLock(fo.Bucket, fo.Prefix); e != nil {
 				common.Exit()
 			}
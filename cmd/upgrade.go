@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 
@@ -71,7 +72,7 @@ var upgradeCmd = &cobra.Command{
 		}
 
 	// upgrade local version
-	if err = g.Download(upgradeBucket, srcPath, srcPath, true, system.RunContext{Bars: bars, Pool: pool, ChunkSize: chunkSize, GentleIO: gentleIO}); err != nil {
+	if err = g.Download(upgradeBucket, srcPath, srcPath, true, system.RunContext{Bars: bars, Group: pool.Group(context.Background()), ChunkSize: chunkSize, GentleIO: gentleIO, DownloadLimit: downloadLimit}); err != nil {
 		common.Exit()
 	}
 		common.Chmod(dstPath, 0766)
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyCmd.Flags().BoolP("r", "r", false, "verify an entire directory tree")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// verifyContent compares two files or objects by content. sha256 is used
+// when both sides have one, which is what makes gs against s3 possible;
+// otherwise crc32c, when both sides have one. Anything else cannot be
+// verified and is reported as such rather than as a match.
+func verifyContent(a, b *system.Attrs) (bool, string, error) {
+	if same, ok := a.SameSHA256(b); ok {
+		return same, "sha256", nil
+	}
+	if a.CalcCRC32C != nil {
+		a.CRC32 = a.CalcCRC32C()
+	}
+	if b.CalcCRC32C != nil {
+		b.CRC32 = b.CalcCRC32C()
+	}
+	if a.CRC32 != 0 && b.CRC32 != 0 {
+		return a.Size == b.Size && a.CRC32 == b.CRC32, "crc32c", nil
+	}
+	return false, "", fmt.Errorf("no checksum available on both sides")
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [-r] [source url] [destination url]",
+	Short: "Verify that copies have the same content",
+	Long:  "Verify that copies have the same content, by sha256 where both sides have one and by crc32c otherwise",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		isRec, _ := cmd.Flags().GetBool("r")
+		src := system.ParseFileObject(args[0])
+		dst := system.ParseFileObject(args[1])
+
+		pairs := map[string][2]*system.FileObject{}
+		switch src.FileType() {
+		case system.FileType_Object:
+			if dst.FileType() != system.FileType_Object {
+				logger.Info(module, "Invalid bucket[%s] with prefix[%s]", dst.Bucket, dst.Prefix)
+				common.Exit()
+				return
+			}
+			pairs[src.Prefix] = [2]*system.FileObject{src, dst}
+		case system.FileType_Directory:
+			if !isRec {
+				logger.Info(module, "Omitting bucket[%s] prefix[%s]. (Did you mean to do verify -r?)", src.Bucket, src.Prefix)
+				common.Exit()
+				return
+			}
+			srcFiles := listRelatively(src, isRec)
+			dstFiles := listRelatively(dst, isRec)
+			for rp, sf := range srcFiles {
+				pairs[rp] = [2]*system.FileObject{sf, dstFiles[rp]}
+			}
+		default:
+			logger.Info(module, "Invalid bucket[%s] with prefix[%s]", src.Bucket, src.Prefix)
+			common.Exit()
+			return
+		}
+
+		failed := 0
+		for name, pair := range pairs {
+			if pair[1] == nil {
+				logger.Info(module, "MISSING %s", name)
+				failed++
+				continue
+			}
+			same, by, err := verifyContent(pair[0].Attributes, pair[1].Attributes)
+			switch {
+			case err != nil:
+				logger.Info(module, "UNVERIFIABLE %s: %s", name, err)
+				failed++
+			case !same:
+				logger.Info(module, "MISMATCH %s (%s)", name, by)
+				failed++
+			default:
+				logger.Debug(module, "OK %s (%s)", name, by)
+			}
+		}
+		if failed > 0 {
+			logger.Info(module, "Verification failed for %d of %d file(s)", failed, len(pairs))
+			common.Exit()
+			return
+		}
+		logger.Info(module, "Verified %d file(s)", len(pairs))
+	},
+}
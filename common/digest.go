@@ -0,0 +1,93 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nextbillion-ai/gsg/logger"
+)
+
+const (
+	// sha256CacheSize is the exact byte length of a sha256 cache file.
+	sha256CacheSize = sha256.Size
+)
+
+// Digest computes checksums over everything written to it, so that one pass
+// over a stream -- the upload itself -- yields them instead of a second read.
+// CRC32C is always computed; SHA-256 only when asked for, since it costs
+// several times as much CPU.
+type Digest struct {
+	crc hash.Hash32
+	sha hash.Hash
+}
+
+// NewDigest creates a Digest, computing SHA-256 as well when withSHA256 is set
+func NewDigest(withSHA256 bool) *Digest {
+	d := &Digest{crc: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+	if withSHA256 {
+		d.sha = sha256.New()
+	}
+	return d
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	_, _ = d.crc.Write(p)
+	if d.sha != nil {
+		_, _ = d.sha.Write(p)
+	}
+	return len(p), nil
+}
+
+// CRC32C returns the crc32c of what has been written so far
+func (d *Digest) CRC32C() uint32 {
+	return d.crc.Sum32()
+}
+
+// SHA256 returns the hex sha256 of what has been written so far, or "" when
+// the digest was created without it.
+func (d *Digest) SHA256() string {
+	if d.sha == nil {
+		return ""
+	}
+	return hex.EncodeToString(d.sha.Sum(nil))
+}
+
+// GetFileSHA256 gets the hex sha256 of a file, or "" when it cannot be read in
+// full. Callers treat "" as unknown rather than as a value, so a read error
+// can never make two different files compare equal.
+//
+// The result is cached under /tmp the same way crc32c is, keyed on path and
+// mtime, because rsync --checksum asks for it on every run.
+func GetFileSHA256(path string) string {
+	path, _ = filepath.Abs(path)
+	if IsPathDirectory(path) {
+		return ""
+	}
+	cacheFileName := GenTempFileName(path, "-", GetFileModificationTime(path).String(), "-sha256")
+	if b, err := os.ReadFile(cacheFileName); err == nil && len(b) == sha256CacheSize {
+		logger.Debug(module, "loaded sha256 [%s] from cache", cacheFileName)
+		return hex.EncodeToString(b)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Debug(module, "failed with %s", err)
+		return ""
+	}
+	defer func() { _ = file.Close() }()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		logger.Debug(module, "failed with %s", err)
+		return ""
+	}
+	sum := h.Sum(nil)
+	if err = WriteFileAtomic(cacheFileName, sum, crc32cCachePerm); err != nil {
+		logger.Debug(module, "write sha256 cachefile [%s] failed with %s", cacheFileName, err)
+	}
+	return hex.EncodeToString(sum)
+}
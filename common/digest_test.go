@@ -0,0 +1,48 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// "hello" has well known digests; the crc32c matches GetFileCRC32C below.
+const (
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+)
+
+func TestDigest(t *testing.T) {
+	d := NewDigest(true)
+	_, _ = d.Write([]byte("hel"))
+	_, _ = d.Write([]byte("lo"))
+	assert.Equal(t, helloSHA256, d.SHA256())
+
+	path := filepath.Join(t.TempDir(), "hello")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	assert.Equal(t, GetFileCRC32C(path), d.CRC32C())
+
+	// Without SHA-256 only the crc32c is computed, and the sha is unknown.
+	d = NewDigest(false)
+	_, _ = d.Write([]byte("hello"))
+	assert.Equal(t, "", d.SHA256())
+	assert.Equal(t, GetFileCRC32C(path), d.CRC32C())
+}
+
+func TestGetFileSHA256(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	cache := GenTempFileName(path, "-", GetFileModificationTime(path).String(), "-sha256")
+	t.Cleanup(func() { _ = os.Remove(cache) })
+
+	assert.Equal(t, helloSHA256, GetFileSHA256(path))
+	// The second call is served from the cache and must agree.
+	assert.FileExists(t, cache)
+	assert.Equal(t, helloSHA256, GetFileSHA256(path))
+
+	// Unknown, never a value, for what cannot be hashed.
+	assert.Equal(t, "", GetFileSHA256(dir))
+	assert.Equal(t, "", GetFileSHA256(filepath.Join(dir, "missing")))
+}
@@ -249,6 +249,25 @@ func writeCRC32cCache(cacheFileName string, result uint32) {
 	logger.Debug(module, "wrote crc32c cachefile : %s", cacheFileName)
 }
 
+// ComputeCRC32C reads r to the end and returns its crc32c. Unlike
+// GetFileCRC32C it never consults the cache, which is what a scrub needs: the
+// point is to read the bytes again.
+func ComputeCRC32C(r io.Reader) (uint32, error) {
+	h32 := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(h32, r); err != nil {
+		return 0, err
+	}
+	return h32.Sum32(), nil
+}
+
+// CachedFileCRC32C returns the crc32c cached for a file at its current mtime,
+// reporting false when none is cached. It is the only checksum a local file
+// has on record.
+func CachedFileCRC32C(path string) (uint32, bool) {
+	path, _ = filepath.Abs(path)
+	return readCRC32cCache(GenTempFileName(path, "-", GetFileModificationTime(path).String(), "-crc32c"))
+}
+
 // GetFileCRC32C gets the crc32c of a file
 func GetFileCRC32C(path string) uint32 {
 	path, _ = filepath.Abs(path)
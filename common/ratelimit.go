@@ -0,0 +1,353 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	"golang.org/x/time/rate"
+)
+
+// ParseByteRate parses a rate such as "50M" or "1.5G" into bytes per second.
+// Units are binary, as everywhere else gsg prints sizes.
+func ParseByteRate(s string) (int64, error) {
+	b, err := bytefmt.ToBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	return int64(b), nil
+}
+
+// NewByteLimiter returns a token bucket allowing bytesPerSecond, with a burst
+// of one second's worth so a full read buffer never has to be split more than
+// it needs to. A rate of zero or less means unlimited and returns nil.
+func NewByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// byteLimiter is what a limitedReader takes its tokens from
+type byteLimiter interface {
+	Burst() int
+	WaitN(ctx context.Context, n int) error
+}
+
+type limitedReader struct {
+	r io.Reader
+	l byteLimiter
+}
+
+// NewRateLimitedReader wraps r so reads through it take tokens from l, one per
+// byte. Several readers sharing one limiter share its rate. A nil limiter
+// returns r unchanged.
+func NewRateLimitedReader(r io.Reader, l *rate.Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// WaitN fails outright for more than the burst, so never ask for more.
+	if burst := lr.l.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.l.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// rateWindow is a daily time range, in minutes since local midnight, with the
+// rate that applies in it. End before Start wraps past midnight.
+type rateWindow struct {
+	start, end int
+	bps        int64
+}
+
+func (w rateWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// RateSchedule is a bandwidth cap that can vary with the time of day. Zero
+// bytes per second means unlimited.
+type RateSchedule struct {
+	windows []rateWindow
+	dflt    int64
+}
+
+func parseRateValue(s string) (int64, error) {
+	switch strings.ToLower(s) {
+	case "0", "off", "unlimited":
+		return 0, nil
+	}
+	return ParseByteRate(s)
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseRateSchedule parses a rate such as "50M", or a comma separated list of
+// "HH:MM-HH:MM=rate" windows in local time plus an optional bare rate for the
+// rest of the day, such as "09:00-18:00=10M,50M". The first window containing
+// the time wins; a window may wrap midnight, as in "22:00-06:00=1G". A rate of
+// 0 or "off" is unlimited, which is also the default outside every window.
+func ParseRateSchedule(s string) (*RateSchedule, error) {
+	rs := &RateSchedule{}
+	hasDefault := false
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		span, value, windowed := strings.Cut(part, "=")
+		if !windowed {
+			if hasDefault {
+				return nil, fmt.Errorf("invalid rate schedule %q: more than one default rate", s)
+			}
+			bps, err := parseRateValue(part)
+			if err != nil {
+				return nil, err
+			}
+			rs.dflt, hasDefault = bps, true
+			continue
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate window %q, expected HH:MM-HH:MM=rate", part)
+		}
+		var w rateWindow
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.bps, err = parseRateValue(value); err != nil {
+			return nil, err
+		}
+		rs.windows = append(rs.windows, w)
+	}
+	return rs, nil
+}
+
+// At returns the bytes per second allowed at t, 0 for unlimited
+func (rs *RateSchedule) At(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range rs.windows {
+		if w.contains(minute) {
+			return w.bps
+		}
+	}
+	return rs.dflt
+}
+
+// ScheduledLimiter is one token bucket following a RateSchedule: every reader
+// it wraps shares the bucket, and its rate is updated as the schedule moves
+// from one window to the next.
+type ScheduledLimiter struct {
+	schedule *RateSchedule
+	now      func() time.Time
+	mu       sync.Mutex
+	current  int64
+	l        *rate.Limiter
+}
+
+// NewScheduledLimiter returns a limiter following rs, or nil for a nil rs so
+// that callers can wrap readers unconditionally.
+func NewScheduledLimiter(rs *RateSchedule) *ScheduledLimiter {
+	if rs == nil {
+		return nil
+	}
+	return &ScheduledLimiter{schedule: rs, now: time.Now, l: rate.NewLimiter(rate.Inf, math.MaxInt32)}
+}
+
+// refresh moves the bucket to the rate the schedule sets for now
+func (sl *ScheduledLimiter) refresh() {
+	bps := sl.schedule.At(sl.now())
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if bps == sl.current {
+		return
+	}
+	sl.current = bps
+	if bps <= 0 {
+		sl.l.SetLimit(rate.Inf)
+		sl.l.SetBurst(math.MaxInt32)
+		return
+	}
+	sl.l.SetLimit(rate.Limit(bps))
+	sl.l.SetBurst(int(bps))
+}
+
+// Burst is the most a single read may take, checked against the schedule
+// before every read.
+func (sl *ScheduledLimiter) Burst() int {
+	sl.refresh()
+	return sl.l.Burst()
+}
+
+// WaitN blocks until n bytes are allowed. The burst can shrink between a
+// read sizing itself by Burst and accounting for it here, when the schedule
+// moves to a lower rate, so n is taken in pieces the bucket can grant.
+func (sl *ScheduledLimiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		take := n
+		if burst := sl.l.Burst(); take > burst {
+			take = burst
+		}
+		if err := sl.l.WaitN(ctx, take); err != nil {
+			return err
+		}
+		n -= take
+	}
+	return nil
+}
+
+// Reader wraps r so reads through it are paced by the limiter. On a nil
+// limiter it returns r unchanged. The wrapper hides any Seek method, which
+// callers streaming an upload should take into account.
+func (sl *ScheduledLimiter) Reader(r io.Reader) io.Reader {
+	if sl == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: sl}
+}
+
+// BucketRates is a requests per second budget for each bucket, with
+// overrides for named buckets. Zero is unlimited.
+type BucketRates struct {
+	dflt    float64
+	buckets map[string]float64
+}
+
+// ParseBucketRates parses a budget such as "100", or "100,logs=20,gs://big=500"
+// to give named buckets their own. A bucket is named bare, for every scheme,
+// or with its scheme for one only; the more specific name wins.
+func ParseBucketRates(s string) (*BucketRates, error) {
+	br := &BucketRates{buckets: map[string]float64{}}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		name, value, named := strings.Cut(part, "=")
+		if !named {
+			value = part
+		}
+		r, err := strconv.ParseFloat(value, 64)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("invalid request rate %q", part)
+		}
+		if named {
+			br.buckets[name] = r
+		} else {
+			br.dflt = r
+		}
+	}
+	return br, nil
+}
+
+func (br *BucketRates) of(scheme, bucket string) float64 {
+	if r, ok := br.buckets[scheme+"://"+bucket]; ok {
+		return r
+	}
+	if r, ok := br.buckets[bucket]; ok {
+		return r
+	}
+	return br.dflt
+}
+
+// RequestLimiter paces API requests so that each bucket gets no more than its
+// budget, with reads (get, stat, list) and writes (put, copy, delete, patch)
+// counted apart: providers throttle them separately. One token bucket is kept
+// per bucket and kind, shared by every goroutine.
+type RequestLimiter struct {
+	reads, writes *BucketRates
+	mu            sync.Mutex
+	limiters      map[string]*rate.Limiter
+}
+
+// Requests is the limiter every backend consults before each request, nil
+// when requests are not limited.
+var Requests *RequestLimiter
+
+// ObserveThrottling asks backends to report throttled requests even when
+// Requests is nil, at the cost of a wrapped transport.
+var ObserveThrottling bool
+
+var throttles atomic.Int64
+
+// ReportThrottle records that a provider asked us to slow down: a 429, a 503,
+// or S3's SlowDown, which it sends as a 503.
+func ReportThrottle() {
+	throttles.Add(1)
+}
+
+// Throttles returns how many requests have been throttled so far
+func Throttles() int64 {
+	return throttles.Load()
+}
+
+// IsThrottleStatus reports whether an http status asks the client to back off
+func IsThrottleStatus(code int) bool {
+	return code == 429 || code == 503
+}
+
+// NewRequestLimiter returns a limiter for the given budgets, either of which
+// may be nil for unlimited.
+func NewRequestLimiter(reads, writes *BucketRates) *RequestLimiter {
+	return &RequestLimiter{reads: reads, writes: writes, limiters: map[string]*rate.Limiter{}}
+}
+
+func (rl *RequestLimiter) limiter(scheme, bucket string, write bool) *rate.Limiter {
+	rates, kind := rl.reads, "read"
+	if write {
+		rates, kind = rl.writes, "write"
+	}
+	if rates == nil {
+		return nil
+	}
+	key := kind + " " + scheme + "://" + bucket
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	l, ok := rl.limiters[key]
+	if !ok {
+		// A burst of one spaces requests evenly, which is what keeps a
+		// provider's own rate detection quiet, rather than letting a second's
+		// worth out at once.
+		if r := rates.of(scheme, bucket); r > 0 {
+			l = rate.NewLimiter(rate.Limit(r), 1)
+		}
+		rl.limiters[key] = l
+	}
+	return l
+}
+
+// Wait blocks until one more request to bucket is within budget. It is safe on
+// a nil limiter, which never blocks.
+func (rl *RequestLimiter) Wait(ctx context.Context, scheme, bucket string, write bool) error {
+	if rl == nil {
+		return nil
+	}
+	l := rl.limiter(scheme, bucket, write)
+	if l == nil {
+		return nil
+	}
+	return l.Wait(ctx)
+}
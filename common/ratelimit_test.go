@@ -0,0 +1,116 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteRate(t *testing.T) {
+	r, err := ParseByteRate("50M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50*1024*1024), r)
+	r, err = ParseByteRate("1K")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), r)
+	_, err = ParseByteRate("fast")
+	assert.Error(t, err)
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	assert.Nil(t, NewByteLimiter(0))
+	src := bytes.NewReader(make([]byte, 10))
+	assert.Equal(t, src, NewRateLimitedReader(src, nil), "no limiter, no wrapper")
+
+	// 3000 bytes at 1000/s with a burst of 1000: the first second's worth is
+	// free, the rest has to wait for about two seconds of tokens.
+	start := time.Now()
+	n, err := io.Copy(io.Discard, NewRateLimitedReader(bytes.NewReader(make([]byte, 3000)), NewByteLimiter(1000)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3000), n)
+	assert.GreaterOrEqual(t, time.Since(start), 1500*time.Millisecond)
+}
+
+func TestParseRateSchedule(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		tm, _ := time.Parse("15:04", hhmm)
+		return tm
+	}
+	rs, err := ParseRateSchedule("50M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50*1024*1024), rs.At(at("12:00")))
+
+	rs, err = ParseRateSchedule("09:00-18:00=10M, 22:00-06:00=off, 1M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10*1024*1024), rs.At(at("09:00")))
+	assert.Equal(t, int64(10*1024*1024), rs.At(at("17:59")))
+	assert.Equal(t, int64(1024*1024), rs.At(at("18:00")))
+	assert.Equal(t, int64(0), rs.At(at("23:30")), "a window wraps past midnight")
+	assert.Equal(t, int64(0), rs.At(at("05:59")))
+
+	rs, err = ParseRateSchedule("09:00-18:00=10M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rs.At(at("20:00")), "unlimited outside every window")
+
+	for _, bad := range []string{"fast", "9-18=10M", "09:00=10M", "09:00-18:00=fast", "1M,2M"} {
+		_, err = ParseRateSchedule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestScheduledLimiter(t *testing.T) {
+	var sl *ScheduledLimiter
+	src := bytes.NewReader(make([]byte, 10))
+	assert.Equal(t, src, sl.Reader(src), "no limiter, no wrapper")
+
+	rs, _ := ParseRateSchedule("00:00-12:00=1K,off")
+	sl = NewScheduledLimiter(rs)
+	now, _ := time.Parse("15:04", "13:00")
+	sl.now = func() time.Time { return now }
+	start := time.Now()
+	_, err := io.Copy(io.Discard, sl.Reader(bytes.NewReader(make([]byte, 100000))))
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second, "unlimited in the afternoon")
+
+	// Moving into the limited window takes effect on the next read.
+	now, _ = time.Parse("15:04", "11:00")
+	start = time.Now()
+	_, err = io.Copy(io.Discard, sl.Reader(bytes.NewReader(make([]byte, 2048))))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRequestLimiter(t *testing.T) {
+	br, err := ParseBucketRates("100, logs=0.5, gs://logs=20")
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, br.of("gs", "data"))
+	assert.Equal(t, 0.5, br.of("s3", "logs"))
+	assert.Equal(t, 20.0, br.of("gs", "logs"), "a scheme qualified name wins")
+	for _, bad := range []string{"fast", "logs=", "-1"} {
+		_, err = ParseBucketRates(bad)
+		assert.Error(t, err, bad)
+	}
+
+	var none *RequestLimiter
+	assert.NoError(t, none.Wait(context.Background(), "gs", "data", true))
+
+	reads, _ := ParseBucketRates("5")
+	rl := NewRequestLimiter(reads, nil)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, rl.Wait(context.Background(), "gs", "data", true), "writes are unlimited")
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	// Each bucket has its own budget: the second request to one bucket waits
+	// a fifth of a second, the first to another does not.
+	assert.NoError(t, rl.Wait(context.Background(), "gs", "a", false))
+	start = time.Now()
+	assert.NoError(t, rl.Wait(context.Background(), "gs", "b", false))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.NoError(t, rl.Wait(context.Background(), "gs", "a", false))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
@@ -0,0 +1,117 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// cloudSDKConfigEnv moves gcloud's config dir, and with it the application
+// default credentials gcloud writes.
+const cloudSDKConfigEnv = "CLOUDSDK_CONFIG"
+
+// scopes are what every client gsg builds asks for
+var scopes = []string{storage.ScopeFullControl, "https://www.googleapis.com/auth/cloud-platform"}
+
+// errNoCredentials is what findCredentials returns when the chain is empty,
+// as opposed to broken.
+var errNoCredentials = errors.New("gcs: no credentials found")
+
+// onGCE reports whether a metadata server can hand out credentials, as on
+// GCE or GKE with workload identity. A var so tests need not probe for one.
+var onGCE = metadata.OnGCE
+
+// gcloudCredentialsFile is where `gcloud auth application-default login`
+// stores the user's credentials.
+func gcloudCredentialsFile() string {
+	dir := os.Getenv(cloudSDKConfigEnv)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	return filepath.Join(dir, "application_default_credentials.json")
+}
+
+// credentialsFromFile reads credentials of any kind gcloud or the console
+// writes: a service account key, user credentials, a workload identity
+// federation config or an impersonated service account.
+func credentialsFromFile(ctx context.Context, path string) (*google.Credentials, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return google.CredentialsFromJSON(ctx, b, scopes...)
+}
+
+// findCredentials goes down the standard chain: the file the env-var names,
+// gcloud's application default credentials, then the metadata server. It
+// stops at the first that is there, and when none is, says what it tried.
+func findCredentials(ctx context.Context) (*google.Credentials, error) {
+	tried := []string{}
+	if path := ConfigPath(); path != "" {
+		creds, err := credentialsFromFile(ctx, path)
+		if err != nil {
+			// Named explicitly, so a broken file is not skipped over.
+			return nil, fmt.Errorf("gcs: failed in loading [%s=%s] with error: %w", googleApplicationCredentialsEnv, path, err)
+		}
+		return creds, nil
+	}
+	tried = append(tried, fmt.Sprintf("env-var [%s]: not set", googleApplicationCredentialsEnv))
+
+	if path := gcloudCredentialsFile(); path != "" {
+		creds, err := credentialsFromFile(ctx, path)
+		if err == nil {
+			return creds, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("gcs: failed in loading gcloud credentials [%s] with error: %w", path, err)
+		}
+		tried = append(tried, fmt.Sprintf("gcloud credentials [%s]: not found", path))
+	}
+
+	if onGCE() {
+		projectID, _ := metadata.ProjectID()
+		return &google.Credentials{ProjectID: projectID, TokenSource: google.ComputeTokenSource("", scopes...)}, nil
+	}
+	tried = append(tried, "metadata server: not reachable")
+	return nil, fmt.Errorf("%w, tried %s", errNoCredentials, strings.Join(tried, "; "))
+}
+
+// credentials returns the options authorising a client with settings s:
+// the credentials file they name or else whatever the chain finds, acting
+// as the service account to impersonate when one is set.
+func credentials(s Profile) ([]option.ClientOption, error) {
+	ctx := context.Background()
+	var creds *google.Credentials
+	var err error
+	if s.CredentialsFile != "" {
+		if creds, err = credentialsFromFile(ctx, s.CredentialsFile); err != nil {
+			return nil, fmt.Errorf("gcs: failed in loading credentials [%s] with error: %w", s.CredentialsFile, err)
+		}
+	} else if creds, err = findCredentials(ctx); err != nil {
+		return nil, err
+	}
+	if s.ImpersonateServiceAccount == "" {
+		return []option.ClientOption{option.WithCredentials(creds)}, nil
+	}
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: s.ImpersonateServiceAccount,
+		Scopes:          scopes,
+	}, option.WithTokenSource(creds.TokenSource))
+	if err != nil {
+		return nil, fmt.Errorf("gcs: impersonating [%s] failed with error: %w", s.ImpersonateServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
@@ -0,0 +1,72 @@
+package gcs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noAmbientCredentials hides whatever credentials the machine running the
+// tests has, and returns gcloud's config dir.
+func noAmbientCredentials(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv(googleApplicationCredentialsEnv, "")
+	t.Setenv(cloudSDKConfigEnv, dir)
+	t.Setenv(emulatorHostEnv, "")
+	saved := onGCE
+	t.Cleanup(func() { onGCE = saved })
+	onGCE = func() bool { return false }
+	return dir
+}
+
+const userCredentials = `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`
+
+func TestFindCredentials(t *testing.T) {
+	dir := noAmbientCredentials(t)
+	ctx := context.Background()
+
+	_, err := findCredentials(ctx)
+	if assert.NotNil(t, err) {
+		// Everything tried is named.
+		assert.Contains(t, err.Error(), googleApplicationCredentialsEnv)
+		assert.Contains(t, err.Error(), filepath.Join(dir, "application_default_credentials.json"))
+		assert.Contains(t, err.Error(), "metadata server")
+	}
+
+	// gcloud auth application-default login
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "application_default_credentials.json"), []byte(userCredentials), 0600))
+	creds, err := findCredentials(ctx)
+	assert.Nil(t, err)
+	assert.NotNil(t, creds)
+
+	// A workload identity federation config, named by the env-var
+	wif := filepath.Join(t.TempDir(), "wif.json")
+	assert.Nil(t, os.WriteFile(wif, []byte(`{
+		"type": "external_account",
+		"audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/p/providers/q",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url": "https://sts.googleapis.com/v1/token",
+		"credential_source": {"file": "/var/run/token"}
+	}`), 0600))
+	t.Setenv(googleApplicationCredentialsEnv, wif)
+	creds, err = findCredentials(ctx)
+	assert.Nil(t, err)
+	assert.NotNil(t, creds)
+
+	// A file named explicitly is not skipped over when it is broken.
+	t.Setenv(googleApplicationCredentialsEnv, filepath.Join(dir, "missing.json"))
+	_, err = findCredentials(ctx)
+	assert.NotNil(t, err)
+}
+
+func TestImpersonation(t *testing.T) {
+	dir := noAmbientCredentials(t)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "application_default_credentials.json"), []byte(userCredentials), 0600))
+
+	opts, err := credentials(Profile{ImpersonateServiceAccount: "sa@project.iam.gserviceaccount.com"})
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+}
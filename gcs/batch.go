@@ -0,0 +1,189 @@
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+)
+
+// maxBatchCalls is the most calls the JSON API takes in one batch request.
+const maxBatchCalls = 100
+
+// batchURL is the JSON API's batch endpoint
+const batchURL = "https://storage.googleapis.com/batch/storage/v1"
+
+// httpClient gets or creates the authorised http client of bucket's profile
+// that batches go through; the storage client has no batch API of its own.
+func (g *GCS) httpClient(bucket string) (*http.Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.profileOf(bucket)
+	if hc, ok := g.hcs[p]; ok {
+		return hc, nil
+	}
+	opts, err := clientOptions(g.settings(p))
+	if err != nil {
+		return nil, err
+	}
+	hc, err := newHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if g.hcs == nil {
+		g.hcs = map[*Profile]*http.Client{}
+	}
+	g.hcs[p] = hc
+	return hc, nil
+}
+
+// DeleteObjects deletes prefixes from bucket in batches of maxBatchCalls,
+// returning an error per key that failed, nil where it was deleted.
+func (g *GCS) DeleteObjects(bucket string, prefixes []string) []error {
+	errs := make([]error, len(prefixes))
+	hc, err := g.httpClient(bucket)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for start := 0; start < len(prefixes); start += maxBatchCalls {
+		end := min(start+maxBatchCalls, len(prefixes))
+		batch := prefixes[start:end]
+		// Each call in a batch counts against the bucket's rate as a
+		// request of its own.
+		for range batch {
+			if err = common.Requests.Wait(context.Background(), "gs", bucket, true); err != nil {
+				break
+			}
+		}
+		var batchErrs []error
+		if err == nil {
+			s := g.bucketSettings(bucket)
+			batchErrs, err = deleteBatch(hc, batchEndpoint(s), bucket, s.BillingProject, batch)
+		}
+		if err != nil {
+			logger.Info(module, "batch delete failed with %s", err)
+			for i := range batch {
+				errs[start+i] = err
+			}
+			continue
+		}
+		copy(errs[start:end], batchErrs)
+		deleted := 0
+		for _, e := range batchErrs {
+			if e == nil {
+				deleted++
+			}
+		}
+		logger.Info(module, "Removing %d objects from bucket[%s]", deleted, bucket)
+	}
+	return errs
+}
+
+// deleteBatch sends one batch request to endpoint deleting prefixes, billed
+// to userProject when it is set, and returns the outcome of each call. The
+// error is for the batch as a whole.
+func deleteBatch(hc *http.Client, endpoint, bucket, userProject string, prefixes []string) ([]error, error) {
+	query := ""
+	if userProject != "" {
+		// Each call is billed on its own, so each names the project.
+		query = "?userProject=" + url.QueryEscape(userProject)
+	}
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for i, prefix := range prefixes {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {"<" + strconv.Itoa(i) + ">"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "DELETE /storage/v1/b/%s/o/%s%s HTTP/1.1\r\n\r\n", url.PathEscape(bucket), url.PathEscape(prefix), query)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("batch returned %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(prefixes))
+	seen := make([]bool, len(prefixes))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-Id"), "<response-"), ">")
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 0 || i >= len(prefixes) {
+			return nil, fmt.Errorf("batch response part with unexpected Content-ID %q", part.Header.Get("Content-Id"))
+		}
+		sub, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, err
+		}
+		seen[i] = true
+		errs[i] = callError(sub)
+		sub.Body.Close()
+	}
+	for i := range seen {
+		if !seen[i] {
+			errs[i] = fmt.Errorf("no response in batch")
+		}
+	}
+	return errs, nil
+}
+
+// callError turns the response to one call of a batch into its error,
+// reporting throttling as any other request would.
+func callError(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	if common.IsThrottleStatus(resp.StatusCode) {
+		common.ReportThrottle()
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
@@ -0,0 +1,80 @@
+package gcs
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/nextbillion-ai/gsg/logger"
+
+	"google.golang.org/api/option"
+)
+
+// emulatorHostEnv names an emulator, such as fake-gcs-server, to use instead
+// of GCS. The storage client honours it itself; gsg only has to not ask for
+// credentials, and send batches there too.
+const emulatorHostEnv = "STORAGE_EMULATOR_HOST"
+
+// emulatorURL returns the base URL of the emulator, nil when there is none.
+// A host without a scheme is reached over http, as the storage client does.
+func emulatorURL() *url.URL {
+	host := os.Getenv(emulatorHostEnv)
+	if host == "" {
+		return nil
+	}
+	if !strings.Contains(host, "://") {
+		return &url.URL{Scheme: "http", Host: host}
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		logger.Info(module, "gcs: ignoring invalid [%s=%s]: %s", emulatorHostEnv, host, err)
+		return nil
+	}
+	return u
+}
+
+// clientOptions returns the options for a client with settings s. Anything
+// is authorised by the credentials found, except that an emulator or an
+// anonymous client needs none, and that without any to be found a client
+// goes without, which is enough for public buckets and for a local stand-in
+// at a custom endpoint. Credentials that are there but broken are an error,
+// whatever the endpoint.
+func clientOptions(s Profile) ([]option.ClientOption, error) {
+	if emulatorURL() != nil {
+		return []option.ClientOption{option.WithoutAuthentication()}, nil
+	}
+	var opts []option.ClientOption
+	if s.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(s.Endpoint))
+	}
+	if s.Anonymous {
+		return append(opts, option.WithoutAuthentication()), nil
+	}
+	creds, err := credentials(s)
+	if errors.Is(err, errNoCredentials) {
+		logger.Debug(module, "%s, going without credentials", err)
+		return append(opts, option.WithoutAuthentication()), nil
+	}
+	if err != nil {
+		logger.Info(module, "%s", err)
+		return nil, err
+	}
+	return append(opts, creds...), nil
+}
+
+// batchEndpoint returns where batches go: the batch path on the emulator or
+// the custom endpoint's host, if there is one.
+func batchEndpoint(s Profile) string {
+	u := emulatorURL()
+	if u == nil && s.Endpoint != "" {
+		var err error
+		if u, err = url.Parse(s.Endpoint); err != nil {
+			return batchURL
+		}
+	}
+	if u == nil {
+		return batchURL
+	}
+	return u.Scheme + "://" + u.Host + "/batch/storage/v1"
+}
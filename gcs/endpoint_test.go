@@ -0,0 +1,66 @@
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchEndpoint(t *testing.T) {
+	t.Setenv(emulatorHostEnv, "")
+	assert.Equal(t, batchURL, batchEndpoint(Profile{}))
+	assert.Equal(t, "https://storage-psc.p.googleapis.com/batch/storage/v1",
+		batchEndpoint(Profile{Endpoint: "https://storage-psc.p.googleapis.com/storage/v1/"}))
+	t.Setenv(emulatorHostEnv, "localhost:4443")
+	assert.Equal(t, "http://localhost:4443/batch/storage/v1", batchEndpoint(Profile{}))
+}
+
+func TestEmulator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Nothing is sent to an emulator to authorise.
+		assert.Empty(t, r.Header.Get("Authorization"))
+		if !strings.HasPrefix(r.URL.Path, "/storage/v1/b/data/o/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"bucket": "data", "name": "a.txt", "size": "5", "generation": "7", "updated": "2024-05-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	g := &GCS{}
+	attrs, err := g.Attributes("data", "a.txt")
+	assert.Nil(t, err)
+	if assert.NotNil(t, attrs) {
+		assert.Equal(t, int64(5), attrs.Size)
+		assert.Equal(t, "7", attrs.Version)
+	}
+}
+
+func TestEndpointWithoutCredentials(t *testing.T) {
+	dir := noAmbientCredentials(t)
+	// Public buckets need none, so their absence is no error.
+	opts, err := clientOptions(Profile{})
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+	opts, err = clientOptions(Profile{Endpoint: "http://localhost:4443/storage/v1/"})
+	assert.Nil(t, err)
+	assert.Len(t, opts, 2)
+
+	// Broken ones are, custom endpoint or not.
+	t.Setenv(googleApplicationCredentialsEnv, filepath.Join(dir, "missing.json"))
+	_, err = clientOptions(Profile{})
+	assert.NotNil(t, err)
+	_, err = clientOptions(Profile{Endpoint: "http://localhost:4443/storage/v1/"})
+	assert.NotNil(t, err)
+	opts, err = clientOptions(Profile{Anonymous: true})
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+}
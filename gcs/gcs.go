@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +24,7 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 const (
@@ -45,12 +48,43 @@ type GCS struct {
 	// and every worker goroutine calls Init, so the check-then-set this
 	// replaces raced: two goroutines could both find a nil client and both
 	// build one, leaving one leaked.
-	mu     sync.Mutex
-	client *storage.Client
+	mu sync.Mutex
+	// clients holds the storage client of each profile, nil for the
+	// defaults, and hcs the http client making the calls the storage client
+	// has no method for, such as batches. Both lazy, and guarded by mu.
+	clients map[*Profile]*storage.Client
+	hcs     map[*Profile]*http.Client
+	// scheme is what the backend is registered as, "gs" when empty, and
+	// profile how it reaches its buckets, nil for the defaults.
+	scheme  string
+	profile *Profile
+	// bucketProfiles are the buckets reached with a profile of their own,
+	// and sourceKeys the customer keys SourceKey reads buckets with.
+	bucketProfiles map[string]*Profile
+	sourceKeys     map[string]string
+	// Endpoint overrides the JSON API endpoint, e.g. with a private service
+	// connect one, or a local stand-in for GCS. Set before first use.
+	Endpoint string
+	// ImpersonateServiceAccount is the service account to act as, with the
+	// credentials found only used to get its tokens. Set before first use.
+	ImpersonateServiceAccount string
+	// KMSKeyName and EncryptionKey are the keys, as in Profile, of every
+	// profile that sets none of its own. Set before first use.
+	KMSKeyName    string
+	EncryptionKey string
+	// BillingProject is billed for requests to requester pays buckets, for
+	// every profile that names none of its own. Set before first use.
+	BillingProject string
+	// Anonymous sends no credentials, even when there are some, which is
+	// all public buckets need to be listed and read. Set before first use.
+	Anonymous bool
 }
 
 func (g *GCS) Scheme() string {
-	return "gs"
+	if g.scheme == "" {
+		return "gs"
+	}
+	return g.scheme
 }
 
 func (g *GCS) toAttrs(attrs *storage.ObjectAttrs) *system.Attrs {
@@ -61,7 +95,22 @@ func (g *GCS) toAttrs(attrs *storage.ObjectAttrs) *system.Attrs {
 		Size:    attrs.Size,
 		CRC32:   attrs.CRC32C,
 		ModTime: GetFileModificationTime(attrs),
+		SHA256:  attrs.Metadata[system.SHA256MetadataKey],
+		MD5:     attrs.MD5,
+		Version: gcsVersion(attrs),
+
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}
+}
+
+// gcsVersion is the generation of an object, or "" for a common prefix, which
+// has none.
+func gcsVersion(attrs *storage.ObjectAttrs) string {
+	if attrs.Generation == 0 {
+		return ""
 	}
+	return strconv.FormatInt(attrs.Generation, 10)
 }
 
 func (g *GCS) toFileObject(attrs *storage.ObjectAttrs, bucket string) *system.FileObject {
@@ -82,41 +131,97 @@ func (g *GCS) toFileObject(attrs *storage.ObjectAttrs, bucket string) *system.Fi
 	return fo
 }
 
-// storageClient gets or creates a gcp storage client
-func (g *GCS) Init(_ ...string) error {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	if g.client != nil {
-		return nil
+// throttledTransport holds every request to GCS until common.Requests allows
+// it, and reports each one GCS throttles. Sitting under the client's retries,
+// it sees each attempt, so a burst of retries after a 429 is paced like
+// everything else.
+type throttledTransport struct {
+	base http.RoundTripper
+}
+
+func (t throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	write := req.Method != http.MethodGet && req.Method != http.MethodHead
+	// A batch holds many calls, each of which its sender has waited for.
+	if !strings.HasPrefix(req.URL.Path, "/batch/") {
+		if err := common.Requests.Wait(req.Context(), "gs", gcsBucketOf(req.URL.Path), write); err != nil {
+			return nil, err
+		}
 	}
-	path := ConfigPath()
-	if path == "" {
-		log := fmt.Sprintf("gcs: expected env-var [%s] not found", googleApplicationCredentialsEnv)
-		logger.Info(module, log)
-		return fmt.Errorf(log)
+	resp, err := t.base.RoundTrip(req)
+	if resp != nil && common.IsThrottleStatus(resp.StatusCode) {
+		common.ReportThrottle()
 	}
-	var err error
-	if _, err = os.Stat(path); err != nil {
-		logger.Info(module, "gcs: failed in loading [%s=%s] with error: %s", googleApplicationCredentialsEnv, path, err)
-		return err
+	return resp, err
+}
+
+// gcsBucketOf names the bucket a request is for, from its path: the JSON API
+// has "/storage/v1/b/<bucket>/..." and "/upload/storage/v1/b/<bucket>/...",
+// and the XML API, which the client reads objects through, "/<bucket>/...".
+func gcsBucketOf(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	for _, api := range []string{"storage/v1/b/", "upload/storage/v1/b/"} {
+		if strings.HasPrefix(path, api) {
+			path = strings.TrimPrefix(path, api)
+			break
+		}
+	}
+	bucket, _, _ := strings.Cut(path, "/")
+	return bucket
+}
+
+// newHTTPClient returns an http client authorised by opts, which goes
+// through throttledTransport when requests are limited or watched.
+func newHTTPClient(opts []option.ClientOption) (*http.Client, error) {
+	var base http.RoundTripper = http.DefaultTransport
+	if common.Requests != nil || common.ObserveThrottling {
+		base = throttledTransport{base: base}
 	}
-	g.client, err = storage.NewClient(context.Background(), option.WithCredentialsFile(path))
+	t, err := htransport.NewTransport(context.Background(), base, append(opts, option.WithScopes(scopes...))...)
 	if err != nil {
-		logger.Info(module, "get client failed with %s", err)
-		return err
+		logger.Info(module, "get transport failed with %s", err)
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// Init gets or creates the storage client of the profile of each of
+// buckets, or of the backend's own profile when given none.
+func (g *GCS) Init(buckets ...string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.clients == nil {
+		g.clients = map[*Profile]*storage.Client{}
+	}
+	profiles := []*Profile{g.profile}
+	if len(buckets) > 0 {
+		profiles = profiles[:0]
+		for _, bucket := range buckets {
+			profiles = append(profiles, g.profileOf(bucket))
+		}
+	}
+	for _, p := range profiles {
+		if _, ok := g.clients[p]; ok {
+			continue
+		}
+		client, err := g.newClient(p)
+		if err != nil {
+			logger.Info(module, "get client failed with %s", err)
+			return err
+		}
+		g.clients[p] = client
 	}
 	return nil
 }
 
 func (g *GCS) GCSAttrs(bucket, prefix string) (*storage.ObjectAttrs, error) {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return nil, err
 	}
 	if prefix == "" {
 		return nil, nil
 	}
-	attrs, err := g.client.Bucket(bucket).Object(prefix).Attrs(context.Background())
+	attrs, err := g.object(bucket, prefix).Attrs(context.Background())
 	if err != nil {
 		logger.Debug(module, "failed with gs://%s/%s %s", bucket, prefix, err)
 		return nil, nil
@@ -134,24 +239,26 @@ func (g *GCS) Attributes(bucket, prefix string) (*system.Attrs, error) {
 	return g.toAttrs(ga), nil
 }
 
-func (g *GCS) batchAttrs(bucket, prefix string, recursive bool) ([]*storage.ObjectAttrs, error) {
+// walkAttrs calls fn with the attributes of every object and, when not
+// recursive, every common prefix under prefix, as each page of the listing
+// arrives. It stops at the first error fn returns.
+func (g *GCS) walkAttrs(bucket, prefix string, recursive bool, fn func(*storage.ObjectAttrs) error) error {
 	var err error
-	if err = g.Init(); err != nil {
-		return nil, err
+	if err = g.Init(bucket); err != nil {
+		return err
 	}
 	var ok bool
 	if ok, err = g.IsObject(bucket, prefix); err != nil {
-		return nil, err
+		return err
 	}
 	if !ok {
 		prefix = common.SetPrefixAsDirectory(prefix)
 	}
-	res := []*storage.ObjectAttrs{}
 	delimiter := "/"
 	if recursive {
 		delimiter = ""
 	}
-	it := g.client.Bucket(bucket).Objects(
+	it := g.bucket(bucket).Objects(
 		context.Background(),
 		&storage.Query{
 			Delimiter:  delimiter,
@@ -168,18 +275,30 @@ func (g *GCS) batchAttrs(bucket, prefix string, recursive bool) ([]*storage.Obje
 		}
 		if err != nil {
 			logger.Info(module, "get objects attributes failed with %s", err)
-			return nil, err
+			return err
 		}
 
 		if count%100000 == 0 {
 			logger.Info(module, "batchAttrs for bucket[%s] prefix[%s] current count[%d]", bucket, prefix, count)
 		}
-		if len(attrs.Name) > 0 && common.IsSubPath(attrs.Name, prefix) {
-			res = append(res, attrs)
-		} else if len(attrs.Prefix) > 0 && common.IsSubPath(attrs.Prefix, prefix) {
-			res = append(res, attrs)
+		if (len(attrs.Name) > 0 && common.IsSubPath(attrs.Name, prefix)) ||
+			(len(attrs.Prefix) > 0 && common.IsSubPath(attrs.Prefix, prefix)) {
+			if err = fn(attrs); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+func (g *GCS) batchAttrs(bucket, prefix string, recursive bool) ([]*storage.ObjectAttrs, error) {
+	res := []*storage.ObjectAttrs{}
+	if err := g.walkAttrs(bucket, prefix, recursive, func(attrs *storage.ObjectAttrs) error {
+		res = append(res, attrs)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
@@ -211,6 +330,14 @@ func (g *GCS) List(bucket, prefix string, recursive bool) ([]*system.FileObject,
 	return fos, nil
 }
 
+// Walk calls fn with each object under a prefix as the listing is paged in,
+// rather than after all of it as List does.
+func (g *GCS) Walk(bucket, prefix string, recursive bool, fn func(*system.FileObject) error) error {
+	return system.EndWalk(g.walkAttrs(bucket, prefix, recursive, func(attrs *storage.ObjectAttrs) error {
+		return fn(g.toFileObject(attrs, bucket))
+	}))
+}
+
 // GetDiskUsageObjects gets disk usage of objects under a prefix
 func (g *GCS) DiskUsage(bucket, prefix string, recursive bool) ([]system.DiskUsage, error) {
 	// is object
@@ -224,16 +351,15 @@ func (g *GCS) DiskUsage(bucket, prefix string, recursive bool) ([]system.DiskUsa
 	}
 	root := system.NewDUTree(prefix, 0, true)
 	// is directory
-	var objs []*storage.ObjectAttrs
-	if objs, err = g.batchAttrs(bucket, prefix, recursive); err != nil {
-		return nil, err
-	}
-	for _, obj := range objs {
+	if err = g.walkAttrs(bucket, prefix, recursive, func(obj *storage.ObjectAttrs) error {
 		name := obj.Name
 		if len(name) == 0 {
 			name = obj.Prefix
 		}
 		root.Add(name, obj.Size, prefix)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	return root.ToDiskUsages(), nil
@@ -241,19 +367,19 @@ func (g *GCS) DiskUsage(bucket, prefix string, recursive bool) ([]system.DiskUsa
 
 func (g *GCS) DeleteObject(bucket, prefix string) error {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return err
 	}
-	return g.client.Bucket(bucket).Object(prefix).Delete(context.Background())
+	return g.object(bucket, prefix).Delete(context.Background())
 }
 
 // DeleteObject deletes an object
 func (g *GCS) Delete(bucket, prefix string) error {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return err
 	}
-	if err = g.client.Bucket(bucket).Object(prefix).Delete(context.Background()); err != nil {
+	if err = g.object(bucket, prefix).Delete(context.Background()); err != nil {
 		logger.Info(module, "delete object failed with %s", err)
 		return err
 	}
@@ -275,10 +401,22 @@ func (g *GCS) Copy(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
 		return fmt.Errorf(log)
 	}
 
+	if err = g.Init(dstBucket); err != nil {
+		return err
+	}
+
 	// copy object
-	src := g.client.Bucket(srcBucket).Object(srcPrefix)
-	dst := g.client.Bucket(dstBucket).Object(dstPrefix)
-	if _, err = dst.CopierFrom(src).Run(context.Background()); err != nil {
+	if !g.sameProfile(srcBucket, dstBucket) {
+		err = g.streamCopy(srcBucket, srcPrefix, dstBucket, dstPrefix, g.toAttrs(ga))
+	} else {
+		src := g.object(srcBucket, srcPrefix)
+		copier := g.object(dstBucket, dstPrefix).CopierFrom(src)
+		dst := g.bucketSettings(dstBucket)
+		copier.StorageClass = dst.StorageClass
+		copier.DestinationKMSKeyName = dst.KMSKeyName
+		_, err = copier.Run(context.Background())
+	}
+	if err != nil {
 		logger.Info(module, "copy object failed with %s", err)
 		return err
 	}
@@ -292,19 +430,19 @@ func (g *GCS) Copy(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
 
 func (g *GCS) GetObjectWriter(bucket, prefix string) (io.WriteCloser, error) {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return nil, err
 	}
-	return g.client.Bucket(bucket).Object(prefix).NewWriter(context.Background()), nil
+	return g.newWriter(context.Background(), bucket, g.object(bucket, prefix)), nil
 }
 
 func (g *GCS) GetObjectReader(bucket, prefix string) (io.ReadCloser, error) {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return nil, err
 	}
 	var rc *storage.Reader
-	if rc, err = g.client.Bucket(bucket).Object(prefix).NewReader(context.Background()); err != nil {
+	if rc, err = g.object(bucket, prefix).NewReader(context.Background()); err != nil {
 		return nil, err
 	}
 	return rc, nil
@@ -347,7 +485,7 @@ func (g *GCS) Download(
 
 	// paralell copy by range
 	var pb *bar.ProgressBar
-	var wg sync.WaitGroup
+	chunks := ctx.Nested()
 	var once sync.Once
 	dstFileTemp := common.GetTempFile(dstFile)
 	for i := 0; i < chunkNumber; i++ {
@@ -359,13 +497,8 @@ func (g *GCS) Download(
 			length = attrs.Size - startByte
 		}
 
-		wg.Add(1)
-		ctx.Pool.AddWithDepth(1,
-			func() {
-				defer func() {
-					wg.Done()
-				}()
-
+		chunks.Go(
+			func(context.Context) error {
 				// create folder and temp file if not exist
 				once.Do(func() {
 					pb = ctx.Bars.New(attrs.Size, fmt.Sprintf("Downloading [%s]:", prefix))
@@ -377,12 +510,12 @@ func (g *GCS) Download(
 				})
 
 				// create reader with offset and length of object
-				rc, err := g.client.Bucket(bucket).Object(prefix).NewRangeReader(
+				rc, err := g.object(bucket, prefix).NewRangeReader(
 					context.Background(), startByte, length,
 				)
 				if err != nil {
 					logger.Info(module, "download object failed when create reader with %s", err)
-					common.Exit()
+					return err
 				}
 				defer func() { _ = rc.Close() }()
 
@@ -391,11 +524,15 @@ func (g *GCS) Download(
 				_, err = fl.Seek(startByte, 0)
 				if err != nil {
 					logger.Info(module, "download object failed when seek for offset with %s", err)
-					common.Exit()
+					return err
 				}
 
 				defer func() { _ = fl.Close() }()
 
+				// Paced here, before either copy below, so --limit-rate holds
+				// whichever mode writes the chunk.
+				body := ctx.DownloadLimit.Reader(rc)
+
 				// If gentle I/O mode, use throttled writer to reduce impact
 				if ctx.GentleIO {
 					logger.Debug(module, "Using gentle I/O mode with throttled writer for chunk at offset %d", startByte)
@@ -406,11 +543,11 @@ func (g *GCS) Download(
 					totalWritten := int64(0)
 
 					for {
-						n, readErr := rc.Read(buf)
+						n, readErr := body.Read(buf)
 						if n > 0 {
 							if _, writeErr := fl.Write(buf[:n]); writeErr != nil {
 								logger.Info(module, "download object failed when write: %s", writeErr)
-								common.Exit()
+								return writeErr
 							}
 							if _, writeErr := pb.Write(buf[:n]); writeErr != nil {
 								// Progress bar write error, non-fatal
@@ -428,7 +565,7 @@ func (g *GCS) Download(
 						}
 						if readErr != nil {
 							logger.Info(module, "download object failed when read: %s", readErr)
-							common.Exit()
+							return readErr
 						}
 					}
 
@@ -439,22 +576,25 @@ func (g *GCS) Download(
 					bufWriter := bufio.NewWriterSize(fl, 4*1024*1024)
 					defer func() { _ = bufWriter.Flush() }()
 
-					if _, err = io.Copy(io.MultiWriter(bufWriter, pb), rc); err != nil {
+					if _, err = io.Copy(io.MultiWriter(bufWriter, pb), body); err != nil {
 						logger.Info(module, "download object failed when write to offet with %s", err)
-						common.Exit()
+						return err
 					}
 
 					if err = bufWriter.Flush(); err != nil {
 						logger.Info(module, "download object failed when flush buffer with %s", err)
-						common.Exit()
+						return err
 					}
 				}
+				return nil
 			},
 		)
 	}
 
 	// move back the temp file
-	wg.Wait()
+	if err = chunks.Wait(); err != nil {
+		return err
+	}
 
 	// sync temp file to disk before rename
 	if tmpFile, err := os.OpenFile(dstFileTemp, os.O_WRONLY, 0766); err == nil {
@@ -477,10 +617,10 @@ func (g *GCS) Download(
 // DoAttemptUnlock takes generation as input and returns potential error
 func (g *GCS) DoAttemptUnlock(bucket, object string, generation int64) error {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return err
 	}
-	o := g.client.Bucket(bucket).Object(object)
+	o := g.object(bucket, object)
 	//delete fails means other client has acquired lock
 	logger.Debug(module, "DoAttemptUnlock: unlock with generation:%d", generation)
 	return o.If(storage.Conditions{GenerationMatch: int64(generation)}).Delete(context.Background())
@@ -517,11 +657,11 @@ func (g *GCS) AttemptUnLock(bucket, object string) error {
 // DoAttemptLock returns generation and potential error
 func (g *GCS) DoAttemptLock(bucket, object string, ttl time.Duration) (int64, error) {
 	var err, err1 error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return 0, err
 	}
 	// write lock
-	o := g.client.Bucket(bucket).Object(object)
+	o := g.object(bucket, object)
 	wc := o.If(storage.Conditions{DoesNotExist: true}).NewWriter(context.Background())
 	_, _ = wc.Write([]byte("1"))
 	err = wc.Close()
@@ -577,7 +717,7 @@ func (g *GCS) AttemptLock(bucket, object string, ttl time.Duration) error {
 // UploadObject uploads an object from a file
 func (g *GCS) Upload(srcFile, bucket, object string, ctx system.RunContext) error {
 	var err error
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return err
 	}
 	// open source file
@@ -600,12 +740,13 @@ func (g *GCS) Upload(srcFile, bucket, object string, ctx system.RunContext) erro
 	// publishing a truncated object under a name that now looks complete.
 	uploadCtx, abort := context.WithCancel(context.Background())
 	defer abort()
-	o := g.client.Bucket(bucket).Object(object)
-	wc := o.NewWriter(uploadCtx)
+	o := g.object(bucket, object)
+	wc := g.newWriter(uploadCtx, bucket, o)
 	wc.Metadata = map[string]string{
 		"goog-reserved-file-mtime": strconv.FormatInt(modTime.UnixNano(), 10),
 	}
-	if _, err = io.Copy(io.MultiWriter(wc, pb), f); err != nil {
+	digest := common.NewDigest(ctx.SHA256)
+	if _, err = io.Copy(io.MultiWriter(wc, pb, digest), ctx.UploadLimit.Reader(f)); err != nil {
 		logger.Info(module, "upload object failed when copy file with %s", err)
 		abort()
 		return err
@@ -616,6 +757,68 @@ func (g *GCS) Upload(srcFile, bucket, object string, ctx system.RunContext) erro
 		logger.Info(module, "upload object failed when finalizing with %s", err)
 		return err
 	}
+	if !ctx.SHA256 {
+		return nil
+	}
+	return g.storeSHA256(o, wc.Attrs(), digest)
+}
+
+// WriteObject stores a stream as an object. As in Upload, a failed read
+// cancels the writer rather than closing it, so nothing is published.
+func (g *GCS) WriteObject(bucket, object string, r io.Reader, attrs *system.Attrs) error {
+	var err error
+	if err = g.Init(bucket); err != nil {
+		return err
+	}
+	writeCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	wc := g.newWriter(writeCtx, bucket, g.object(bucket, object))
+	wc.ContentType = attrs.ContentType
+	wc.Metadata = map[string]string{}
+	for k, v := range attrs.Metadata {
+		wc.Metadata[k] = v
+	}
+	if !attrs.ModTime.IsZero() {
+		wc.Metadata["goog-reserved-file-mtime"] = strconv.FormatInt(attrs.ModTime.UnixNano(), 10)
+	}
+	if _, err = io.Copy(wc, r); err != nil {
+		logger.Info(module, "write object failed when copy stream with %s", err)
+		abort()
+		return err
+	}
+	if err = wc.Close(); err != nil {
+		logger.Info(module, "write object failed when finalizing with %s", err)
+		return err
+	}
+	return nil
+}
+
+// storeSHA256 records the sha256 computed while uploading as custom metadata.
+//
+// Metadata on a GCS writer is sent when the upload starts, before any byte has
+// been hashed, so it is patched onto the object afterwards instead. The patch
+// is conditioned on the generation just written, so it can never label a
+// newer object with this content's digest. The streamed crc32c is compared
+// with the one GCS computed first: a mismatch means the bytes hashed are not
+// the bytes stored, and the sha256 would be a lie.
+func (g *GCS) storeSHA256(o *storage.ObjectHandle, attrs *storage.ObjectAttrs, digest *common.Digest) error {
+	if attrs.CRC32C != digest.CRC32C() {
+		log := fmt.Sprintf("upload of gs://%s/%s stored crc32c [%d] but streamed [%d]", attrs.Bucket, attrs.Name, attrs.CRC32C, digest.CRC32C())
+		logger.Info(module, log)
+		return fmt.Errorf(log)
+	}
+	metadata := map[string]string{}
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	metadata[system.SHA256MetadataKey] = digest.SHA256()
+	if _, err := o.If(storage.Conditions{GenerationMatch: attrs.Generation}).Update(
+		context.Background(), storage.ObjectAttrsToUpdate{Metadata: metadata},
+	); err != nil {
+		logger.Info(module, "upload object failed when storing sha256 with %s", err)
+		return err
+	}
+	logger.Debug(module, "stored sha256 [%s] on gs://%s/%s", digest.SHA256(), attrs.Bucket, attrs.Name)
 	return nil
 }
 
@@ -638,11 +841,11 @@ func (g *GCS) Move(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
 func (g *GCS) Cat(bucket, prefix string) ([]byte, error) {
 	var err error
 	// create reader
-	if err = g.Init(); err != nil {
+	if err = g.Init(bucket); err != nil {
 		return nil, err
 	}
 	var rc io.ReadCloser
-	if rc, err = g.client.Bucket(bucket).Object(prefix).NewReader(context.Background()); err != nil {
+	if rc, err = g.object(bucket, prefix).NewReader(context.Background()); err != nil {
 		logger.Info(module, "output object failed when create reader with %s", err)
 		return nil, err
 	}
@@ -678,9 +881,15 @@ func (g *GCS) IsObject(bucket, prefix string) (bool, error) {
 // case 3: gs://abc/def/ -> gs://abc/def/ : true
 // case 4: gs://abc/def -> gs://abc/def : false
 func (g *GCS) IsDirectory(bucket, prefix string) (bool, error) {
-	var err error
-	var objs []*storage.ObjectAttrs
-	if objs, err = g.batchAttrs(bucket, prefix, false); err != nil {
+	// Two entries settle it, so there is no need to list the rest.
+	objs := []*storage.ObjectAttrs{}
+	if err := system.EndWalk(g.walkAttrs(bucket, prefix, false, func(attrs *storage.ObjectAttrs) error {
+		objs = append(objs, attrs)
+		if len(objs) > 1 {
+			return system.ErrStopWalk
+		}
+		return nil
+	})); err != nil {
 		return false, err
 	}
 	if len(objs) == 1 {
@@ -1,7 +1,14 @@
 package gcs
 
 import (
+	"bufio"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/nextbillion-ai/gsg/common"
@@ -14,6 +21,56 @@ func TestConfigPath(t *testing.T) {
 	assert.Equal(t, "test_path", ConfigPath())
 }
 
+func TestGCSBucketOf(t *testing.T) {
+	assert.Equal(t, "data", gcsBucketOf("/storage/v1/b/data/o"))
+	assert.Equal(t, "data", gcsBucketOf("/storage/v1/b/data/o/a/b.txt"))
+	assert.Equal(t, "data", gcsBucketOf("/upload/storage/v1/b/data/o"))
+	// The XML API, which reads go through; an object may be named b.
+	assert.Equal(t, "data", gcsBucketOf("/data/storage/v1/b/x"))
+	assert.Equal(t, "data", gcsBucketOf("/data/a/b.txt"))
+}
+
+func TestDeleteBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		assert.Nil(t, err)
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			call, err := http.ReadRequest(bufio.NewReader(part))
+			assert.Nil(t, err)
+			assert.Equal(t, http.MethodDelete, call.Method)
+			assert.Equal(t, "payer", call.URL.Query().Get("userProject"))
+			id := strings.Trim(part.Header.Get("Content-Id"), "<>")
+			out, _ := mw.CreatePart(map[string][]string{
+				"Content-Type": {"application/http"},
+				"Content-Id":   {"<response-" + id + ">"},
+			})
+			// Objects under missing/ are not there.
+			if strings.Contains(call.URL.EscapedPath(), "missing%2F") {
+				body := `{"error":{"code":404,"message":"No such object"}}`
+				fmt.Fprintf(out, "HTTP/1.1 404 Not Found\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				continue
+			}
+			fmt.Fprint(out, "HTTP/1.1 204 No Content\r\n\r\n")
+		}
+		mw.Close()
+	}))
+	defer srv.Close()
+
+	errs, err := deleteBatch(srv.Client(), srv.URL, "b", "payer", []string{"dir/a", "missing/b", "dir/c d"})
+	assert.Nil(t, err)
+	assert.Len(t, errs, 3)
+	assert.Nil(t, errs[0])
+	assert.Contains(t, errs[1].Error(), "No such object")
+	assert.Nil(t, errs[2])
+}
+
 /*
 func TestEuqalCRC32C(t *testing.T) {
 	g := GCS{}
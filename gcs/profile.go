@@ -0,0 +1,229 @@
+package gcs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// Profile is everything that can differ between the buckets one process
+// uses: whose credentials reach them, through which endpoint, who pays for
+// requests, and how new objects are stored. Unset fields fall back to the
+// flags and the standard credential chain.
+type Profile struct {
+	// CredentialsFile is used instead of the chain findCredentials goes down,
+	// e.g. a service account key of another project.
+	CredentialsFile string `json:"credentials_file"`
+	// ImpersonateServiceAccount is the service account to act as
+	ImpersonateServiceAccount string `json:"impersonate_service_account"`
+	// Endpoint overrides the JSON API endpoint
+	Endpoint string `json:"endpoint"`
+	// BillingProject is billed for requests to requester pays buckets
+	BillingProject string `json:"billing_project"`
+	// KMSKeyName is the Cloud KMS key new objects are encrypted with (CMEK),
+	// as projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>
+	KMSKeyName string `json:"kms_key_name"`
+	// EncryptionKey is a base64 AES-256 key objects are encrypted with, and
+	// read with, by GCS (CSEK). GCS keeps only its sha256.
+	EncryptionKey string `json:"encryption_key"`
+	// StorageClass is given to every object written, e.g. NEARLINE
+	StorageClass string `json:"storage_class"`
+	// Anonymous sends no credentials, for public buckets
+	Anonymous bool `json:"anonymous"`
+}
+
+// New returns a GCS backend registered under scheme, which uses p, or the
+// defaults when p is nil.
+func New(scheme string, p *Profile) *GCS {
+	return &GCS{scheme: scheme, profile: p}
+}
+
+// SetBucketProfile uses p for bucket instead of the backend's own profile
+func (g *GCS) SetBucketProfile(bucket string, p *Profile) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.bucketProfiles == nil {
+		g.bucketProfiles = map[string]*Profile{}
+	}
+	g.bucketProfiles[bucket] = p
+}
+
+// profileOf returns the profile of bucket, nil for the defaults. Callers
+// hold mu.
+func (g *GCS) profileOf(bucket string) *Profile {
+	if p, ok := g.bucketProfiles[bucket]; ok {
+		return p
+	}
+	return g.profile
+}
+
+// SourceKey has bucket, which a command only reads from, read with the
+// customer key key rather than the backend's own keys, "" for none, unless
+// its profile has keys of its own. A copy's source may well be in the clear
+// while its destination is not, and GCS refuses a key an object was not
+// written with.
+func (g *GCS) SourceKey(bucket, key string) error {
+	if _, err := encryptionKey(Profile{EncryptionKey: key}); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sourceKeys == nil {
+		g.sourceKeys = map[string]string{}
+	}
+	g.sourceKeys[bucket] = key
+	return nil
+}
+
+// settings returns p with whatever it leaves unset taken from the backend's
+// own fields, which the flags set. The keys replace the flags' as a pair.
+func (g *GCS) settings(p *Profile) Profile {
+	s := Profile{
+		Endpoint:                  g.Endpoint,
+		ImpersonateServiceAccount: g.ImpersonateServiceAccount,
+		BillingProject:            g.BillingProject,
+		Anonymous:                 g.Anonymous,
+		KMSKeyName:                g.KMSKeyName,
+		EncryptionKey:             g.EncryptionKey,
+	}
+	if p == nil {
+		return s
+	}
+	if p.CredentialsFile != "" {
+		s.CredentialsFile = p.CredentialsFile
+	}
+	if p.ImpersonateServiceAccount != "" {
+		s.ImpersonateServiceAccount = p.ImpersonateServiceAccount
+	}
+	if p.Endpoint != "" {
+		s.Endpoint = p.Endpoint
+	}
+	if p.BillingProject != "" {
+		s.BillingProject = p.BillingProject
+	}
+	s.StorageClass = p.StorageClass
+	s.Anonymous = s.Anonymous || p.Anonymous
+	if p.KMSKeyName != "" || p.EncryptionKey != "" {
+		s.KMSKeyName, s.EncryptionKey = p.KMSKeyName, p.EncryptionKey
+	}
+	return s
+}
+
+// bucketSettings returns the settings bucket is reached with: its profile's,
+// with the key SourceKey gave it in place of the flags'.
+func (g *GCS) bucketSettings(bucket string) Profile {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.profileOf(bucket)
+	s := g.settings(p)
+	if key, ok := g.sourceKeys[bucket]; ok && (p == nil || p.KMSKeyName == "" && p.EncryptionKey == "") {
+		s.KMSKeyName, s.EncryptionKey = "", key
+	}
+	return s
+}
+
+// sameProfile reports whether two buckets are reached the same way, so that
+// one can be copied to the other server side.
+func (g *GCS) sameProfile(a, b string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.profileOf(a) == g.profileOf(b)
+}
+
+// bucket returns the handle of bucket, through the client of its profile and
+// billed to its billing project. Init has made the client.
+func (g *GCS) bucket(name string) *storage.BucketHandle {
+	g.mu.Lock()
+	p := g.profileOf(name)
+	client, s := g.clients[p], g.settings(p)
+	g.mu.Unlock()
+	h := client.Bucket(name)
+	if s.BillingProject != "" {
+		h = h.UserProject(s.BillingProject)
+	}
+	return h
+}
+
+// object returns the handle of an object, which reads and writes it with
+// the encryption key of its bucket's profile. Init has checked the key.
+func (g *GCS) object(bucket, name string) *storage.ObjectHandle {
+	o := g.bucket(bucket).Object(name)
+	if key, _ := encryptionKey(g.bucketSettings(bucket)); key != nil {
+		o = o.Key(key)
+	}
+	return o
+}
+
+// newWriter returns a writer of object, storing it in the storage class of
+// its bucket's profile, and encrypting it with the profile's KMS key.
+func (g *GCS) newWriter(ctx context.Context, bucket string, o *storage.ObjectHandle) *storage.Writer {
+	s := g.bucketSettings(bucket)
+	wc := o.NewWriter(ctx)
+	wc.StorageClass = s.StorageClass
+	wc.KMSKeyName = s.KMSKeyName
+	return wc
+}
+
+// encryptionKey decodes the customer-supplied key of settings s, nil when
+// there is none. A bucket has that or a KMS key, not both.
+func encryptionKey(s Profile) ([]byte, error) {
+	if s.EncryptionKey == "" {
+		return nil, nil
+	}
+	if s.KMSKeyName != "" {
+		return nil, fmt.Errorf("gcs: both a KMS key and an encryption key are set")
+	}
+	key, err := base64.StdEncoding.DecodeString(s.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: encryption key is not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("gcs: encryption key is %d bytes, AES-256 needs 32", len(key))
+	}
+	return key, nil
+}
+
+// newClient builds the storage client for a profile. Callers hold mu.
+func (g *GCS) newClient(p *Profile) (*storage.Client, error) {
+	s := g.settings(p)
+	if _, err := encryptionKey(s); err != nil {
+		return nil, err
+	}
+	opts, err := clientOptions(s)
+	if err != nil {
+		return nil, err
+	}
+	if common.Requests != nil || common.ObserveThrottling {
+		// The client only takes a transport as a whole http client, which
+		// then has to carry the credentials and scopes itself.
+		var hc *http.Client
+		if hc, err = newHTTPClient(opts); err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithHTTPClient(hc)}
+		if s.Endpoint != "" {
+			opts = append(opts, option.WithEndpoint(s.Endpoint))
+		}
+	}
+	return storage.NewClient(context.Background(), opts...)
+}
+
+// streamCopy copies an object between buckets of different profiles by
+// reading it from one and writing it to the other, since neither profile's
+// credentials need reach the other bucket. attrs are the source's, whose
+// content type, metadata and mtime the copy keeps, as a server-side copy would.
+func (g *GCS) streamCopy(srcBucket, srcPrefix, dstBucket, dstPrefix string, attrs *system.Attrs) error {
+	rc, err := g.GetObjectReader(srcBucket, srcPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	return g.WriteObject(dstBucket, dstPrefix, rc, attrs)
+}
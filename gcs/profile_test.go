@@ -0,0 +1,220 @@
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/bar"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEncryptionKey is 32 bytes of 'k', base64
+const testEncryptionKey = "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="
+
+func TestSettings(t *testing.T) {
+	g := New("archive", &Profile{BillingProject: "payer", StorageClass: "ARCHIVE"})
+	g.Endpoint = "https://storage-psc.p.googleapis.com/storage/v1/"
+	g.SetBucketProfile("other", &Profile{Endpoint: "http://localhost:4443/storage/v1/"})
+	assert.Equal(t, "archive", g.Scheme())
+	assert.Equal(t, "gs", (&GCS{}).Scheme())
+
+	s := g.bucketSettings("data")
+	assert.Equal(t, g.Endpoint, s.Endpoint)
+	assert.Equal(t, "payer", s.BillingProject)
+	assert.Equal(t, "ARCHIVE", s.StorageClass)
+	s = g.bucketSettings("other")
+	assert.Equal(t, "http://localhost:4443/storage/v1/", s.Endpoint)
+	assert.Empty(t, s.BillingProject)
+	assert.True(t, g.sameProfile("data", "more"))
+	assert.False(t, g.sameProfile("data", "other"))
+}
+
+func TestCredentialsFile(t *testing.T) {
+	dir := noAmbientCredentials(t)
+	path := filepath.Join(dir, "other.json")
+	assert.Nil(t, os.WriteFile(path, []byte(userCredentials), 0600))
+
+	opts, err := credentials(Profile{CredentialsFile: path})
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+	_, err = credentials(Profile{CredentialsFile: filepath.Join(dir, "missing.json")})
+	assert.NotNil(t, err)
+}
+
+func TestBillingProject(t *testing.T) {
+	var userProjects []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userProjects = append(userProjects, r.URL.Query().Get("userProject"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"bucket": "data", "name": "a.txt", "size": "5", "generation": "7"}`)
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	g := &GCS{}
+	g.SetBucketProfile("paid", &Profile{BillingProject: "payer"})
+	_, err := g.Attributes("paid", "a.txt")
+	assert.Nil(t, err)
+	_, err = g.Attributes("data", "a.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"payer", ""}, userProjects)
+
+	// --billing-project covers every bucket a profile does not.
+	userProjects = nil
+	g = &GCS{BillingProject: "flag"}
+	g.SetBucketProfile("paid", &Profile{BillingProject: "payer"})
+	_, err = g.Attributes("paid", "a.txt")
+	assert.Nil(t, err)
+	_, err = g.Attributes("data", "a.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"payer", "flag"}, userProjects)
+}
+
+func TestEncryptionKey(t *testing.T) {
+	key, err := encryptionKey(Profile{})
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	key, err = encryptionKey(Profile{EncryptionKey: testEncryptionKey})
+	assert.Nil(t, err)
+	assert.Len(t, key, 32)
+	for _, s := range []Profile{
+		{EncryptionKey: "not base64!"},
+		{EncryptionKey: "c2hvcnQ="},
+		{EncryptionKey: testEncryptionKey, KMSKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k"},
+	} {
+		_, err = encryptionKey(s)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	kmsKeyNames, encryptionKeys := map[string]string{}, map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kmsKeyNames[r.Method+" "+r.URL.Path] = r.URL.Query().Get("kmsKeyName")
+		encryptionKeys[r.Method+" "+r.URL.Path] = r.Header.Get("X-Goog-Encryption-Key")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"bucket": "data", "name": "a.txt", "size": "5", "generation": "7"}`)
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	kms := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	g := &GCS{KMSKeyName: kms}
+	g.SetBucketProfile("secret", &Profile{EncryptionKey: testEncryptionKey})
+	assert.Nil(t, g.WriteObject("data", "a.txt", strings.NewReader("hello"), &system.Attrs{Size: 5}))
+	_, err := g.Attributes("secret", "a.txt")
+	assert.Nil(t, err)
+
+	assert.Equal(t, kms, kmsKeyNames["POST /upload/storage/v1/b/data/o"])
+	assert.Empty(t, encryptionKeys["POST /upload/storage/v1/b/data/o"])
+	// A profile's keys replace the flags'.
+	assert.Equal(t, testEncryptionKey, encryptionKeys["GET /storage/v1/b/secret/o/a.txt"])
+
+	g.SetBucketProfile("broken", &Profile{EncryptionKey: "c2hvcnQ="})
+	assert.NotNil(t, g.Init("broken"))
+	g = &GCS{EncryptionKey: "c2hvcnQ="}
+	assert.NotNil(t, g.Init("data"))
+	assert.NotNil(t, (&GCS{}).SourceKey("data", "c2hvcnQ="))
+}
+
+// The flag's customer key alone reads and writes CSEK objects: stat and
+// download send it as well as uploads.
+func TestEncryptionKeyReads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Goog-Encryption-Key") != testEncryptionKey {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/storage/v1/") {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"bucket": "secret", "name": "a.txt", "size": "5", "generation": "7", "customerEncryption": {"encryptionAlgorithm": "AES256", "keySha256": "sha"}}`)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	g := &GCS{EncryptionKey: testEncryptionKey}
+	attrs, err := g.GCSAttrs("secret", "a.txt")
+	assert.Nil(t, err)
+	if assert.NotNil(t, attrs) {
+		assert.NotNil(t, attrs.CustomerKeySHA256)
+	}
+	dst := filepath.Join(t.TempDir(), "a.txt")
+	assert.Nil(t, g.Download("secret", "a.txt", dst, false, system.RunContext{Bars: &bar.Container{}}))
+	b, err := os.ReadFile(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+// A bucket SourceKey reads in the clear is copied from without the key,
+// which GCS would refuse, to a bucket the flag's key writes with.
+func TestSourceKey(t *testing.T) {
+	var copySourceKeys, encryptionKeys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encryptionKeys = append(encryptionKeys, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Goog-Encryption-Key"))
+		copySourceKeys = append(copySourceKeys, r.Header.Get("X-Goog-Copy-Source-Encryption-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		object := `{"bucket": "plain", "name": "a.txt", "size": "5", "generation": "7"}`
+		if strings.Contains(r.URL.Path, "/rewriteTo/") {
+			object = `{"done": true, "resource": ` + object + `}`
+		}
+		fmt.Fprint(w, object)
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	g := &GCS{EncryptionKey: testEncryptionKey}
+	assert.Nil(t, g.SourceKey("plain", ""))
+	assert.Nil(t, g.Copy("plain", "a.txt", "secret", "a.txt"))
+	assert.Contains(t, encryptionKeys, "GET /storage/v1/b/plain/o/a.txt ")
+	assert.Contains(t, encryptionKeys, "POST /storage/v1/b/plain/o/a.txt/rewriteTo/b/secret/o/a.txt "+testEncryptionKey)
+	for _, key := range copySourceKeys {
+		assert.Empty(t, key)
+	}
+}
+
+// A copy between profiles streams through gsg, and keeps what a server-side
+// copy would: content type, metadata and mtime.
+func TestStreamCopy(t *testing.T) {
+	var upload string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			b, _ := io.ReadAll(r.Body)
+			upload = string(b)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"bucket": "dst", "name": "a.txt", "size": "5", "generation": "8"}`)
+		case strings.HasPrefix(r.URL.Path, "/storage/v1/"):
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"bucket": "src", "name": "a.txt", "size": "5", "generation": "7", "contentType": "text/plain",
+				"metadata": {"gsg-sha256": "2cf24dba", "goog-reserved-file-mtime": "1700000000000000000"}}`)
+		default:
+			fmt.Fprint(w, "hello")
+		}
+	}))
+	defer srv.Close()
+	t.Setenv(emulatorHostEnv, srv.URL)
+	t.Setenv(googleApplicationCredentialsEnv, "")
+
+	g := &GCS{}
+	g.SetBucketProfile("dst", &Profile{BillingProject: "other"})
+	assert.Nil(t, g.Copy("src", "a.txt", "dst", "a.txt"))
+	assert.Contains(t, upload, `"contentType":"text/plain"`)
+	assert.Contains(t, upload, `"gsg-sha256":"2cf24dba"`)
+	assert.Contains(t, upload, `"goog-reserved-file-mtime":"1700000000000000000"`)
+	assert.Contains(t, upload, "hello")
+}
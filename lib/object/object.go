@@ -223,6 +223,27 @@ func (o *Object) Write(from io.Reader) error {
 	return nil
 }
 
+func (o *Object) fileObject() *system.FileObject {
+	return &system.FileObject{System: o._system, Bucket: o.bucket, Prefix: o.prefix, Remote: true}
+}
+
+// CopyTo copies the object to every one of dsts, reading it only once and
+// writing to all of them in parallel. Each destination is verified on its own;
+// the errors are per destination, in the order given, nil where it succeeded.
+func (o *Object) CopyTo(dsts ...*Object) []error {
+	fos := make([]*system.FileObject, len(dsts))
+	for i, d := range dsts {
+		fos[i] = d.fileObject()
+	}
+	errs := system.FanOut(o.fileObject(), fos, system.RunContext{})
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = parseError(err)
+		}
+	}
+	return errs
+}
+
 func (o *Object) Delete() error {
 	switch o.scheme {
 	case "s3":
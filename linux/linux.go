@@ -1,10 +1,13 @@
 package linux
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"os/exec"
@@ -39,6 +42,7 @@ type FileAttrs struct {
 	Size         int64
 	ModTime      time.Time
 	CalcCRC32C   func() uint32
+	CalcSHA256   func() string
 }
 
 // GetRealPath gets real path of a directory
@@ -66,6 +70,7 @@ func (l *Linux) toAttrs(attrs *FileAttrs) *system.Attrs {
 		Size:       attrs.Size,
 		ModTime:    attrs.ModTime,
 		CalcCRC32C: attrs.CalcCRC32C,
+		CalcSHA256: attrs.CalcSHA256,
 	}
 }
 
@@ -96,6 +101,7 @@ func (l *Linux) attrs(_, prefix string) *FileAttrs {
 		Name:         name,
 		Size:         common.GetFileSize(prefix),
 		CalcCRC32C:   func() uint32 { return common.GetFileCRC32C(prefix) },
+		CalcSHA256:   func() string { return common.GetFileSHA256(prefix) },
 		ModTime:      common.GetFileModificationTime(prefix),
 	}
 	return res
@@ -145,38 +151,85 @@ func (l *Linux) BatchAttributes(bucket, prefix string, recursive bool) ([]*syste
 
 // ListObjects lists objects under a prefix
 func (l *Linux) List(bucket, prefix string, isRec bool) ([]*system.FileObject, error) {
+	objs := []*system.FileObject{}
+	if err := l.Walk(bucket, prefix, isRec, func(fo *system.FileObject) error {
+		objs = append(objs, fo)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// Walk calls fn with each file under a dir as find prints it, rather than
+// after find has finished as List does.
+func (l *Linux) Walk(bucket, prefix string, isRec bool, fn func(*system.FileObject) error) error {
 	dir := GetRealPath(prefix)
 	if !common.IsPathExist(dir) {
 		// Syncing into a directory that does not exist yet is normal, so an
 		// absent path lists empty rather than failing.
-		return []*system.FileObject{}, nil
+		return nil
 	}
-	var stdout []byte
-	var err error
+	var cmd *exec.Cmd
 	if isRec {
-		stdout, err = exec.Command("find", dir, "-type", "f", "-print0").Output()
+		cmd = exec.Command("find", dir, "-type", "f", "-print0")
 	} else {
-		stdout, err = exec.Command("find", dir, "-maxdepth", "1", "-type", "f", "-print0").Output()
+		cmd = exec.Command("find", dir, "-maxdepth", "1", "-type", "f", "-print0")
 	}
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		logger.Info(module, "listing [%s] failed with %s", dir, err)
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanPaths)
+	count := 0
+	for scanner.Scan() {
+		count++
+		if count%100000 == 0 {
+			logger.Info(module, "ListObjects %d", count)
+		}
+		fo := l.listedFileObject(scanner.Text())
+		if fo == nil {
+			continue
+		}
+		if err = fn(fo); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return system.EndWalk(err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		logger.Info(module, "listing [%s] failed with %s", dir, err)
+		return err
+	}
+	if err = cmd.Wait(); err != nil {
 		// A failed listing must not be reported as an empty one. find exits
 		// non-zero for a single unreadable subdirectory too, and rsync -d
 		// deletes everything at the destination the source listing omits, so
 		// swallowing this could wipe the destination.
 		logger.Info(module, "listing [%s] failed with %s", dir, err)
-		return nil, err
+		return err
 	}
-	res := splitPaths(stdout)
-	objs := []*system.FileObject{}
-	for i, v := range res {
-		if i%100000 == 0 && i != 0 {
-			logger.Info(module, "ListObjects %d/%d", i, len(res))
-		}
-		if fo := l.listedFileObject(v); fo != nil {
-			objs = append(objs, fo)
-		}
+	return nil
+}
+
+// scanPaths is a bufio.SplitFunc for the NUL-terminated output of find
+// -print0, as splitPaths is for all of it at once.
+func scanPaths(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
 	}
-	return objs, nil
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
 
 // listedFileObject turns one path from find into a file object, or nil if it
@@ -330,6 +383,44 @@ func (l *Linux) Cat(bucket, path string) ([]byte, error) {
 	return bs, nil
 }
 
+// GetObjectReader opens a file for reading
+func (l *Linux) GetObjectReader(_, path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// WriteObject writes a stream to a temp file beside path and renames it into
+// place once complete, so a failed read never leaves a short file behind.
+func (l *Linux) WriteObject(_, path string, r io.Reader, attrs *system.Attrs) error {
+	folder, _ := common.ParseFile(path)
+	if !common.IsPathExist(folder) {
+		common.CreateFolder(folder)
+	}
+	tmp := common.GetTempFile(path)
+	f, err := os.Create(tmp)
+	if err != nil {
+		logger.Debug(module, "failed with %s", err)
+		return err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		logger.Debug(module, "failed with %s", err)
+		_ = os.Remove(tmp)
+		return err
+	}
+	if !attrs.ModTime.IsZero() {
+		common.SetFileModificationTime(path, attrs.ModTime)
+	}
+	logger.Info(module, "Writing to path[%s]", path)
+	return nil
+}
+
 // IsDirectoryOrObject checks if is a directory or an object
 func IsDirectoryOrObject(path string) bool {
 	return common.IsPathDirectory(path) || common.IsPathFile(path)
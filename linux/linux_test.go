@@ -1,10 +1,17 @@
 package linux
 
 import (
+	"bufio"
+	"errors"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/system"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,6 +28,46 @@ func TestSplitPaths(t *testing.T) {
 	assert.Equal(t, []string{"/a", "", "/b"}, splitPaths([]byte("/a\x00\x00/b\x00")))
 }
 
+func TestScanPaths(t *testing.T) {
+	split := func(in string) []string {
+		sc := bufio.NewScanner(strings.NewReader(in))
+		sc.Split(scanPaths)
+		out := []string{}
+		for sc.Scan() {
+			out = append(out, sc.Text())
+		}
+		return out
+	}
+	assert.Equal(t, []string{"/a\nb", "/c"}, split("/a\nb\x00/c\x00"))
+	assert.Equal(t, []string{"/a"}, split("/a"))
+	assert.Equal(t, []string{}, split(""))
+}
+
+// Walk gives what List does, and a callback can end it early without error.
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+	seen := 0
+	assert.NoError(t, (&Linux{}).Walk("", dir, true, func(fo *system.FileObject) error {
+		assert.NotNil(t, fo.Attributes)
+		seen++
+		return nil
+	}))
+	assert.Equal(t, 3, seen)
+
+	seen = 0
+	assert.NoError(t, (&Linux{}).Walk("", dir, true, func(fo *system.FileObject) error {
+		seen++
+		return system.ErrStopWalk
+	}))
+	assert.Equal(t, 1, seen)
+
+	stop := errors.New("stop")
+	assert.Equal(t, stop, (&Linux{}).Walk("", dir, true, func(fo *system.FileObject) error { return stop }))
+}
+
 // A failed listing must not look like an empty one: rsync -d deletes whatever
 // the source listing omits, so swallowing the error could wipe the destination.
 func TestListReportsFailureInsteadOfEmpty(t *testing.T) {
@@ -137,3 +184,34 @@ func TestListSkipsTempFiles(t *testing.T) {
 	assert.Equal(t, []string{"keep.txt"}, prefixes(t, dir, true))
 	assert.Equal(t, 1, len(ListTempFiles(dir, true)))
 }
+
+// One destination failing must not cost the others their copy. Each copy
+// keeps the source's mtime, as a download does.
+func TestFanOut(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	assert.NoError(t, os.WriteFile(src, []byte("fan out once, land everywhere"), 0644))
+	mtime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(src, mtime, mtime))
+	blocker := filepath.Join(dir, "blocker")
+	assert.NoError(t, os.WriteFile(blocker, nil, 0644))
+
+	l := &Linux{}
+	dsts := []*system.FileObject{
+		{System: l, Prefix: filepath.Join(dir, "a", "copy.bin")},
+		{System: l, Prefix: filepath.Join(blocker, "copy.bin")},
+		{System: l, Prefix: filepath.Join(dir, "b", "c", "copy.bin")},
+	}
+	errs := system.FanOut(&system.FileObject{System: l, Prefix: src}, dsts, system.RunContext{})
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1], "a file is in the way of the destination's directory")
+	assert.NoError(t, errs[2])
+	for _, i := range []int{0, 2} {
+		b, err := os.ReadFile(dsts[i].Prefix)
+		assert.NoError(t, err)
+		assert.Equal(t, "fan out once, land everywhere", string(b))
+		assert.True(t, mtime.Equal(common.GetFileModificationTime(dsts[i].Prefix)))
+		_, err = os.Stat(common.GetTempFile(dsts[i].Prefix))
+		assert.True(t, os.IsNotExist(err), "no temp file is left behind")
+	}
+}
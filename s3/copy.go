@@ -0,0 +1,246 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nextbillion-ai/gsg/bar"
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxCopyObjectSize is the largest source CopyObject takes; anything
+	// larger is copied a part at a time.
+	maxCopyObjectSize = 5 << 30
+	// minCopyPartSize is the part size of a multipart copy, unless the
+	// object needs larger parts to fit in maxCopyParts.
+	minCopyPartSize = 256 << 20
+	maxCopyParts    = 10000
+)
+
+// copySource is the CopySource of an object, as CopyObject has always sent it
+func copySource(bucket, prefix string) *string {
+	return aws.String(fmt.Sprintf("%v/%v", bucket, prefix))
+}
+
+// copyPartSize is the part size for a multipart copy of an object of size:
+// minCopyPartSize, or whole MiBs large enough to need no more than
+// maxCopyParts parts.
+func copyPartSize(size int64) int64 {
+	part := (size + maxCopyParts - 1) / maxCopyParts
+	part = (part + 1<<20 - 1) &^ (1<<20 - 1)
+	return max(part, minCopyPartSize)
+}
+
+// CopyWithContext copies an object server side. Objects too large for
+// CopyObject are copied in parts with UploadPartCopy, on the child of
+// ctx.Group when there is one, with their metadata and checksum algorithm carried over and
+// progress shown on ctx.Bars.
+func (s *S3) CopyWithContext(srcBucket, srcPrefix, dstBucket, dstPrefix string, ctx system.RunContext) error {
+	var err error
+	if err = s.Init(srcBucket, dstBucket); err != nil {
+		return err
+	}
+	var s3a *S3Attributes
+	if s3a, err = s.S3Attrs(srcBucket, srcPrefix); err != nil {
+		return err
+	}
+	// check object
+	if s3a == nil {
+		log := fmt.Sprintf("failed with bucket[%s] prefix[%s] not an object", srcBucket, srcPrefix)
+		logger.Debug(module, log)
+		return fmt.Errorf(log)
+	}
+
+	if size := s3ObjectSize(s3a); !s.sameProfile(srcBucket, dstBucket) {
+		// Neither side's credentials need reach the other, and one store
+		// cannot copy from another, so the bytes pass through here.
+		err = s.streamCopy(srcBucket, srcPrefix, dstBucket, dstPrefix, size)
+	} else if size > maxCopyObjectSize {
+		err = s.multipartCopy(srcBucket, srcPrefix, dstBucket, dstPrefix, size, ctx)
+	} else {
+		_, err = s.clientOf(dstBucket).CopyObject(context.TODO(), &s3.CopyObjectInput{
+			Bucket:       aws.String(dstBucket),
+			Key:          aws.String(dstPrefix),
+			CopySource:   copySource(srcBucket, srcPrefix),
+			StorageClass: s.storageClass(dstBucket),
+		})
+	}
+	if err != nil {
+		logger.Info(module, "copy object failed with %s", err)
+		return err
+	}
+	logger.Info(
+		module,
+		"Copying from bucket[%s] prefix[%s] to bucket[%s] prefix[%s]",
+		srcBucket, srcPrefix, dstBucket, dstPrefix,
+	)
+	return nil
+}
+
+// copyChecksum picks the checksum algorithm of a multipart copy: the one the
+// source was stored with. A crc is kept as a checksum of the whole object,
+// and the source's own value, when it is one, is handed to S3 to verify the
+// copy against; other algorithms can only be checksums of the parts.
+func copyChecksum(head *s3.HeadObjectOutput) (types.ChecksumAlgorithm, types.ChecksumType, *types.Checksum) {
+	whole := func(v *string) bool {
+		return v != nil && !strings.Contains(*v, "-") && head.ChecksumType != types.ChecksumTypeComposite
+	}
+	switch {
+	case head.ChecksumCRC32C != nil:
+		if whole(head.ChecksumCRC32C) {
+			return types.ChecksumAlgorithmCrc32c, types.ChecksumTypeFullObject, &types.Checksum{ChecksumCRC32C: head.ChecksumCRC32C}
+		}
+		return types.ChecksumAlgorithmCrc32c, types.ChecksumTypeFullObject, nil
+	case head.ChecksumCRC32 != nil:
+		if whole(head.ChecksumCRC32) {
+			return types.ChecksumAlgorithmCrc32, types.ChecksumTypeFullObject, &types.Checksum{ChecksumCRC32: head.ChecksumCRC32}
+		}
+		return types.ChecksumAlgorithmCrc32, types.ChecksumTypeFullObject, nil
+	case head.ChecksumCRC64NVME != nil:
+		if whole(head.ChecksumCRC64NVME) {
+			return types.ChecksumAlgorithmCrc64nvme, types.ChecksumTypeFullObject, &types.Checksum{ChecksumCRC64NVME: head.ChecksumCRC64NVME}
+		}
+		return types.ChecksumAlgorithmCrc64nvme, types.ChecksumTypeFullObject, nil
+	case head.ChecksumSHA256 != nil:
+		return types.ChecksumAlgorithmSha256, types.ChecksumTypeComposite, nil
+	case head.ChecksumSHA1 != nil:
+		return types.ChecksumAlgorithmSha1, types.ChecksumTypeComposite, nil
+	}
+	return "", "", nil
+}
+
+// multipartCopy copies an object of size in parts of copyPartSize, aborting
+// the upload if any part fails.
+func (s *S3) multipartCopy(srcBucket, srcPrefix, dstBucket, dstPrefix string, size int64, ctx system.RunContext) error {
+	head, err := s.clientOf(srcBucket).HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket:       aws.String(srcBucket),
+		Key:          aws.String(srcPrefix),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return err
+	}
+	algorithm, checksumType, whole := copyChecksum(head)
+	storageClass := s.storageClass(dstBucket)
+	if storageClass == "" {
+		storageClass = types.StorageClass(head.StorageClass)
+	}
+	// Unlike CopyObject, a multipart upload starts with nothing of the
+	// source's, so everything worth keeping is set again here.
+	mu, err := s.clientOf(dstBucket).CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(dstBucket),
+		Key:                aws.String(dstPrefix),
+		Metadata:           head.Metadata,
+		ContentType:        head.ContentType,
+		ContentEncoding:    head.ContentEncoding,
+		ContentDisposition: head.ContentDisposition,
+		ContentLanguage:    head.ContentLanguage,
+		CacheControl:       head.CacheControl,
+		StorageClass:       storageClass,
+		ChecksumAlgorithm:  algorithm,
+		ChecksumType:       checksumType,
+	})
+	if err != nil {
+		return err
+	}
+
+	partSize := copyPartSize(size)
+	parts := make([]types.CompletedPart, (size+partSize-1)/partSize)
+	logger.Debug(module, "Copying [%s] with %d part(s), part size: %d bytes, total size: %d bytes", srcPrefix, len(parts), partSize, size)
+	var pb *bar.ProgressBar
+	if ctx.Bars != nil {
+		pb = ctx.Bars.New(size, fmt.Sprintf("Copying [%s]:", srcPrefix))
+	}
+	// Parts are nested work of the copy's own job, one depth further down.
+	group := ctx.Nested()
+	for i := range parts {
+		start := int64(i) * partSize
+		end := min(start+partSize, size) - 1
+		copyPart := func(context.Context) error {
+			var out *s3.UploadPartCopyOutput
+			if e := common.DoWithRetrySimple(func() (e error) {
+				out, e = s.clientOf(dstBucket).UploadPartCopy(context.TODO(), &s3.UploadPartCopyInput{
+					Bucket:          aws.String(dstBucket),
+					Key:             aws.String(dstPrefix),
+					UploadId:        mu.UploadId,
+					PartNumber:      aws.Int32(int32(i + 1)),
+					CopySource:      copySource(srcBucket, srcPrefix),
+					CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				})
+				return e
+			}); e != nil {
+				return fmt.Errorf("part %d: %w", i+1, e)
+			}
+			r := out.CopyPartResult
+			parts[i] = types.CompletedPart{
+				PartNumber:        aws.Int32(int32(i + 1)),
+				ETag:              r.ETag,
+				ChecksumCRC32:     r.ChecksumCRC32,
+				ChecksumCRC32C:    r.ChecksumCRC32C,
+				ChecksumCRC64NVME: r.ChecksumCRC64NVME,
+				ChecksumSHA1:      r.ChecksumSHA1,
+				ChecksumSHA256:    r.ChecksumSHA256,
+			}
+			if pb != nil {
+				pb.IncrBy(end - start + 1)
+			}
+			return nil
+		}
+		group.Go(copyPart)
+	}
+	err = group.Wait()
+	if err != nil {
+		if _, ae := s.clientOf(dstBucket).AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(dstBucket),
+			Key:      aws.String(dstPrefix),
+			UploadId: mu.UploadId,
+		}); ae != nil {
+			logger.Info(module, "abort multipart copy to s3://%s/%s failed with %s", dstBucket, dstPrefix, ae)
+		}
+		return err
+	}
+
+	ci := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dstBucket),
+		Key:             aws.String(dstPrefix),
+		UploadId:        mu.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		ChecksumType:    checksumType,
+	}
+	if whole != nil {
+		ci.ChecksumCRC32 = whole.ChecksumCRC32
+		ci.ChecksumCRC32C = whole.ChecksumCRC32C
+		ci.ChecksumCRC64NVME = whole.ChecksumCRC64NVME
+	}
+	_, err = s.clientOf(dstBucket).CompleteMultipartUpload(context.TODO(), ci)
+	return err
+}
+
+// streamCopy copies an object between buckets of different profiles by
+// reading it from one and writing it to the other. The copy keeps the
+// source's content type and metadata, as CopyObject would; WriteObject
+// uploads it in parts when it is too large for one request.
+func (s *S3) streamCopy(srcBucket, srcPrefix, dstBucket, dstPrefix string, size int64) error {
+	head, err := s.S3Head(srcBucket, srcPrefix)
+	if err != nil {
+		return err
+	}
+	rc, err := s.GetObjectReader(srcBucket, srcPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	return s.WriteObject(dstBucket, dstPrefix, rc, &system.Attrs{
+		Size:        size,
+		ContentType: aws.ToString(head.ContentType),
+		Metadata:    head.Metadata,
+	})
+}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// customerKeyAlgorithm is the only algorithm SSE-C has
+const customerKeyAlgorithm = "AES256"
+
+// customerKeyMD5 checks that key is a base64 AES-256 key, and returns the
+// base64 md5 S3 wants sent along to verify it arrived intact.
+func customerKeyMD5(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("customer key is not base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return "", fmt.Errorf("customer key is %d bytes, AES-256 needs 32", len(raw))
+	}
+	sum := md5.Sum(raw)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// encryption returns the middleware that has every request carry the keys of
+// settings ps, nil when there are none. New objects are encrypted with the
+// KMS key; the customer key is sent with every request that touches the
+// content or its attributes, copies included, since S3 cannot read without
+// it. A bucket is either, not both.
+func encryption(ps Profile) (func(*middleware.Stack) error, error) {
+	if ps.SSEKMSKeyID == "" && ps.SSECustomerKey == "" {
+		return nil, nil
+	}
+	if ps.SSEKMSKeyID != "" && ps.SSECustomerKey != "" {
+		return nil, fmt.Errorf("both a KMS key and a customer key are set")
+	}
+	var keyMD5 string
+	if ps.SSECustomerKey != "" {
+		var err error
+		if keyMD5, err = customerKeyMD5(ps.SSECustomerKey); err != nil {
+			return nil, err
+		}
+	}
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GsgEncryption",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				setEncryption(in.Parameters, ps.SSEKMSKeyID, ps.SSECustomerKey, keyMD5)
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}, nil
+}
+
+// setEncryption sets the encryption fields an operation's input has: the KMS
+// key on writes, and the customer key on anything reading or writing an
+// object, and on the source of a copy.
+func setEncryption(params any, kmsKeyID, customerKey, customerKeyMD5 string) {
+	if kmsKeyID != "" {
+		setInputField(params, "ServerSideEncryption", types.ServerSideEncryptionAwsKms)
+		setInputField(params, "SSEKMSKeyId", aws.String(kmsKeyID))
+	}
+	if customerKey != "" {
+		for _, prefix := range []string{"", "CopySource"} {
+			setInputField(params, prefix+"SSECustomerAlgorithm", aws.String(customerKeyAlgorithm))
+			setInputField(params, prefix+"SSECustomerKey", aws.String(customerKey))
+			setInputField(params, prefix+"SSECustomerKeyMD5", aws.String(customerKeyMD5))
+		}
+	}
+}
+
+// encrypted reports whether objects of bucket are written with a key of
+// gsg's, which leaves their ETags no md5.
+func (s *S3) encrypted(bucket string) bool {
+	ps := s.bucketSettings(bucket)
+	return ps.SSEKMSKeyID != "" || ps.SSECustomerKey != ""
+}
+
+// S3Head reads an object's headers, which say how it is encrypted
+func (s *S3) S3Head(bucket, prefix string) (*s3.HeadObjectOutput, error) {
+	var err error
+	if err = s.Init(bucket); err != nil {
+		return nil, err
+	}
+	return s.clientOf(bucket).HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(prefix),
+	})
+}
@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nextbillion-ai/gsg/bar"
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// testCustomerKey is 32 bytes of 'k', base64
+const testCustomerKey = "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="
+
+func TestCustomerKeyMD5(t *testing.T) {
+	if sum, err := customerKeyMD5(testCustomerKey); err != nil || sum == "" {
+		t.Errorf("customerKeyMD5 = %q, %v", sum, err)
+	}
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := customerKeyMD5(key); err == nil {
+			t.Errorf("customerKeyMD5(%q) should fail", key)
+		}
+	}
+	if _, err := encryption(Profile{SSEKMSKeyID: "alias/data", SSECustomerKey: testCustomerKey}); err == nil {
+		t.Errorf("a KMS key and a customer key together should fail")
+	}
+}
+
+func TestSetEncryption(t *testing.T) {
+	put := &s3.PutObjectInput{}
+	setEncryption(put, "alias/data", "", "")
+	if put.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(put.SSEKMSKeyId) != "alias/data" {
+		t.Errorf("put = %+v", put)
+	}
+	get := &s3.GetObjectInput{}
+	setEncryption(get, "alias/data", "", "")
+	if get.SSECustomerKey != nil {
+		t.Errorf("a KMS key is not sent with reads")
+	}
+
+	cp := &s3.UploadPartCopyInput{}
+	setEncryption(cp, "", testCustomerKey, "md5")
+	if aws.ToString(cp.SSECustomerKey) != testCustomerKey || aws.ToString(cp.CopySourceSSECustomerKey) != testCustomerKey ||
+		aws.ToString(cp.SSECustomerAlgorithm) != customerKeyAlgorithm || aws.ToString(cp.CopySourceSSECustomerKeyMD5) != "md5" {
+		t.Errorf("part copy = %+v", cp)
+	}
+	// Listing has nothing to encrypt.
+	setEncryption(&s3.ListObjectsV2Input{}, "alias/data", testCustomerKey, "md5")
+}
+
+func TestEncryptionHeaders(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	headers := map[string]http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.Method+" "+r.URL.Path] = r.Header.Clone()
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer srv.Close()
+
+	store := Endpoint{URL: srv.URL, PathStyle: true}
+	s := New("s3", &Profile{Endpoint: store})
+	s.SSEKMSKeyID = "alias/data"
+	s.SetBucketProfile("secret", &Profile{Endpoint: store, SSECustomerKey: testCustomerKey})
+	if err := s.SourceKey("plain", ""); err != nil {
+		t.Fatalf("SourceKey: %v", err)
+	}
+	if err := s.Init("kms", "secret", "plain"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := s.WriteObject("kms", "a.txt", strings.NewReader("hello"), &system.Attrs{Size: 5}); err != nil {
+		t.Fatalf("WriteObject: %v", err)
+	}
+	if _, err := s.S3Head("secret", "a.txt"); err != nil {
+		t.Fatalf("S3Head: %v", err)
+	}
+	put := headers["PUT /kms/a.txt"]
+	if put.Get("X-Amz-Server-Side-Encryption") != "aws:kms" || put.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "alias/data" {
+		t.Errorf("put headers = %v", put)
+	}
+	head := headers["HEAD /secret/a.txt"]
+	if head.Get("X-Amz-Server-Side-Encryption-Customer-Key") != testCustomerKey || head.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" {
+		t.Errorf("head headers = %v", head)
+	}
+	if !s.encrypted("kms") || !s.encrypted("secret") {
+		t.Errorf("buckets with keys should report no md5")
+	}
+	if s.encrypted("plain") {
+		t.Errorf("a bucket SourceKey gave no key has none")
+	}
+}
+
+// The flag's customer key alone reads and writes SSE-C objects: stat and
+// download send it as well as uploads.
+func TestCustomerKeyReads(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	srv := tlsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != testCustomerKey {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.URL.Query().Has("attributes"):
+			_, _ = w.Write([]byte(`<GetObjectAttributesResponse><ObjectSize>5</ObjectSize></GetObjectAttributesResponse>`))
+		default:
+			w.Header().Set("Content-Length", "5")
+			w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", customerKeyAlgorithm)
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte("hello"))
+			}
+		}
+	})
+
+	s := New("s3", &Profile{Endpoint: Endpoint{URL: srv.URL, PathStyle: true}})
+	s.SSECustomerKey = testCustomerKey
+	head, err := s.S3Head("secret", "a.txt")
+	if err != nil {
+		t.Fatalf("S3Head: %v", err)
+	}
+	if aws.ToString(head.SSECustomerAlgorithm) != customerKeyAlgorithm {
+		t.Errorf("head = %+v", head)
+	}
+	dst := filepath.Join(t.TempDir(), "a.txt")
+	if err = s.Download("secret", "a.txt", dst, false, system.RunContext{Bars: &bar.Container{}}); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "hello" {
+		t.Errorf("downloaded %q", b)
+	}
+}
+
+// A bucket SourceKey reads in the clear is copied from without the key,
+// which S3 would refuse, to a bucket the flag's key writes with.
+func TestSourceKey(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var mu sync.Mutex
+	keys := map[string]string{}
+	srv := tlsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys[r.Method+" "+r.URL.Path] = r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key")
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodPut:
+			_, _ = io.Copy(io.Discard, r.Body)
+		case r.URL.Query().Has("attributes"):
+			_, _ = w.Write([]byte(`<GetObjectAttributesResponse><ObjectSize>5</ObjectSize></GetObjectAttributesResponse>`))
+		default:
+			w.Header().Set("Content-Length", "5")
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte("hello"))
+			}
+		}
+	})
+
+	s := New("s3", &Profile{Endpoint: Endpoint{URL: srv.URL, PathStyle: true}})
+	s.SSECustomerKey = testCustomerKey
+	if err := s.SourceKey("plain", ""); err != nil {
+		t.Fatalf("SourceKey: %v", err)
+	}
+	if s.sameProfile("plain", "secret") {
+		t.Errorf("a server-side copy would send the destination's key for the source")
+	}
+	if err := s.CopyWithContext("plain", "a.txt", "secret", "a.txt", system.RunContext{}); err != nil {
+		t.Fatalf("CopyWithContext: %v", err)
+	}
+	if keys["GET /plain/a.txt"] != "" || keys["HEAD /plain/a.txt"] != "" {
+		t.Errorf("source read with a key: %v", keys)
+	}
+	if keys["PUT /secret/a.txt"] != testCustomerKey {
+		t.Errorf("destination written without the key: %v", keys)
+	}
+
+	if err := s.SourceKey("broken", "c2hvcnQ="); err == nil {
+		t.Errorf("a short key should fail")
+	}
+	s = New("s3", &Profile{Endpoint: Endpoint{URL: srv.URL, PathStyle: true}})
+	s.SSECustomerKey = "c2hvcnQ="
+	if err := s.Init("secret"); err == nil {
+		t.Errorf("a short flag key should fail Init")
+	}
+}
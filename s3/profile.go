@@ -0,0 +1,277 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nextbillion-ai/gsg/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultEndpointRegion signs requests to an endpoint configured without a
+// region. S3-compatible stores either ignore the region or, like MinIO, use
+// this one unless told otherwise.
+const defaultEndpointRegion = "us-east-1"
+
+// Endpoint points the S3 backend at an S3-compatible store, such as
+// Cloudflare R2, MinIO or Ceph, instead of AWS.
+type Endpoint struct {
+	// URL is the store's base URL, e.g. https://<account>.r2.cloudflarestorage.com.
+	// Empty keeps AWS, which only makes sense with Region set.
+	URL string `json:"endpoint"`
+	// Region is used as is, instead of asking S3 where each bucket is.
+	Region string `json:"region"`
+	// PathStyle addresses buckets as URL/bucket rather than bucket.URL, which
+	// stores without wildcard DNS need.
+	PathStyle bool `json:"path_style"`
+	// ChecksumCompat sends and checks checksums only where an operation
+	// requires them, and reads attributes with HeadObject, for stores that
+	// implement neither the newer checksum headers nor GetObjectAttributes.
+	ChecksumCompat bool `json:"checksum_compat"`
+}
+
+// Profile is everything that can differ between the buckets one process
+// uses: where they are, whose credentials reach them, and how new objects
+// are stored. Unset fields fall back to the usual AWS defaults.
+type Profile struct {
+	Endpoint
+	// AWSProfile names a profile of the shared AWS config and credentials
+	// files to take credentials and settings from.
+	AWSProfile string `json:"aws_profile"`
+	// AccessKeyID, SecretAccessKey and SessionToken are static credentials,
+	// used instead of any found in the environment.
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	// StorageClass is given to every object written, e.g. STANDARD_IA
+	StorageClass string `json:"storage_class"`
+	// SSEKMSKeyID is the KMS key, by id, ARN or alias, new objects are
+	// encrypted with (SSE-KMS).
+	SSEKMSKeyID string `json:"sse_kms_key_id"`
+	// SSECustomerKey is a base64 AES-256 key objects are encrypted with, and
+	// read with, by S3 (SSE-C). S3 keeps only its md5.
+	SSECustomerKey string `json:"sse_customer_key"`
+	// RequestPayer agrees to pay for requests to requester pays buckets
+	RequestPayer bool `json:"request_payer"`
+	// Anonymous sends unsigned requests, for public buckets
+	Anonymous bool `json:"anonymous"`
+}
+
+// clientKey identifies a client: one per profile, region and keys, which
+// SourceKey can make differ between buckets of one profile
+type clientKey struct {
+	profile     *Profile
+	region      string
+	kmsKeyID    string
+	customerKey string
+}
+
+// New returns an S3 backend registered under scheme, which uses p, or the
+// AWS defaults when p is nil.
+func New(scheme string, p *Profile) *S3 {
+	return &S3{scheme: scheme, profile: p}
+}
+
+// SetBucketProfile uses p for bucket instead of the backend's own profile
+func (s *S3) SetBucketProfile(bucket string, p *Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bucketProfiles == nil {
+		s.bucketProfiles = map[string]*Profile{}
+	}
+	s.bucketProfiles[bucket] = p
+	delete(s.buckets, bucket)
+}
+
+// profileOf returns the profile of bucket, nil for the defaults. Callers
+// hold mu.
+func (s *S3) profileOf(bucket string) *Profile {
+	if p, ok := s.bucketProfiles[bucket]; ok {
+		return p
+	}
+	return s.profile
+}
+
+// SourceKey has bucket, which a command only reads from, read with the
+// customer key key rather than the backend's own keys, "" for none, unless
+// its profile has keys of its own. A copy's source may well be in the clear
+// while its destination is not, and S3 refuses a customer key an object was
+// not written with. Call before the bucket's first use.
+func (s *S3) SourceKey(bucket, key string) error {
+	if _, err := encryption(Profile{SSECustomerKey: key}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sourceKeys == nil {
+		s.sourceKeys = map[string]string{}
+	}
+	s.sourceKeys[bucket] = key
+	delete(s.buckets, bucket)
+	return nil
+}
+
+// sameProfile reports whether two buckets are reached the same way, so that
+// one can be copied to the other server side. A server-side copy sends the
+// destination's customer key for the source too, so theirs must match.
+func (s *S3) sameProfile(a, b string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profileOf(a) == s.profileOf(b) && s.settingsOf(a).SSECustomerKey == s.settingsOf(b).SSECustomerKey
+}
+
+// settings returns p, nil for the defaults, with what the backend's own
+// fields, which the flags set, add to it. A profile's keys replace the
+// flags' as a pair.
+func (s *S3) settings(p *Profile) Profile {
+	var ps Profile
+	if p != nil {
+		ps = *p
+	}
+	ps.RequestPayer = ps.RequestPayer || s.RequestPayer
+	ps.Anonymous = ps.Anonymous || s.Anonymous
+	if ps.SSEKMSKeyID == "" && ps.SSECustomerKey == "" {
+		ps.SSEKMSKeyID, ps.SSECustomerKey = s.SSEKMSKeyID, s.SSECustomerKey
+	}
+	return ps
+}
+
+// settingsOf returns the settings of bucket: its profile's, with the key
+// SourceKey gave it in place of the flags'. Callers hold mu.
+func (s *S3) settingsOf(bucket string) Profile {
+	p := s.profileOf(bucket)
+	ps := s.settings(p)
+	if key, ok := s.sourceKeys[bucket]; ok && (p == nil || p.SSEKMSKeyID == "" && p.SSECustomerKey == "") {
+		ps.SSEKMSKeyID, ps.SSECustomerKey = "", key
+	}
+	return ps
+}
+
+// bucketSettings returns the settings bucket is reached with
+func (s *S3) bucketSettings(bucket string) Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settingsOf(bucket)
+}
+
+// checksumCompat reports whether bucket is in a store that wants
+// Endpoint.ChecksumCompat.
+func (s *S3) checksumCompat(bucket string) bool {
+	return s.bucketSettings(bucket).ChecksumCompat
+}
+
+// storageClass returns the storage class for objects written to bucket, ""
+// for the bucket's default.
+func (s *S3) storageClass(bucket string) types.StorageClass {
+	return types.StorageClass(s.bucketSettings(bucket).StorageClass)
+}
+
+// loadConfig loads the sdk config for settings ps. An anonymous one, or one
+// without any credentials to be found, signs nothing, which is enough to list
+// and read public buckets. Credentials that are there but fail to load, an
+// expired SSO session say, are an error rather than a reason to go anonymous.
+func loadConfig(ps Profile) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if ps.AWSProfile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(ps.AWSProfile))
+	}
+	switch {
+	case ps.Anonymous:
+		opts = append(opts, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	case ps.AccessKeyID != "":
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(ps.AccessKeyID, ps.SecretAccessKey, ps.SessionToken)))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return cfg, err
+	}
+	if ps.Anonymous {
+		return cfg, nil
+	}
+	if _, err = cfg.Credentials.Retrieve(context.TODO()); err != nil {
+		if !noCredentials(cfg, err) {
+			return cfg, fmt.Errorf("loading credentials failed with %w", err)
+		}
+		logger.Debug(module, "no credentials found (%s), going anonymous", err)
+		cfg.Credentials = aws.AnonymousCredentials{}
+	}
+	return cfg, nil
+}
+
+// noCredentials reports whether err, from retrieving cfg's credentials, means
+// that there are none: the sdk only falls back to the instance's role when
+// nothing else is configured, and that fails when there is no role either.
+func noCredentials(cfg aws.Config, err error) bool {
+	return aws.IsCredentialsProvider(cfg.Credentials, (*ec2rolecreds.Provider)(nil)) &&
+		strings.Contains(err.Error(), "no EC2 IMDS role found")
+}
+
+// regionOf returns the region to sign the requests for bucket with: the
+// profile's, or for AWS wherever the bucket is.
+func regionOf(ps Profile, bucket string) (string, error) {
+	switch {
+	case ps.Region != "":
+		return ps.Region, nil
+	case ps.URL != "":
+		return defaultEndpointRegion, nil
+	}
+	return bucketRegion(bucket)
+}
+
+// newClient builds the client for settings ps in region. moved, if not nil,
+// is called with a bucket S3 redirects elsewhere, when the region was looked
+// up rather than configured.
+func newClient(cfg aws.Config, ps Profile, region string, moved func(bucket string) string) (*s3.Client, error) {
+	keys, err := encryption(ps)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+		o.APIOptions = append(o.APIOptions, throttle)
+		if moved != nil && ps.Region == "" && ps.URL == "" {
+			o.APIOptions = append(o.APIOptions, regionRedirect(moved))
+			o.EndpointResolverV2 = regionalEndpoints{o.EndpointResolverV2}
+		}
+		if ps.RequestPayer {
+			o.APIOptions = append(o.APIOptions, requestPayer)
+		}
+		if keys != nil {
+			o.APIOptions = append(o.APIOptions, keys)
+		}
+		if ps.URL != "" {
+			o.BaseEndpoint = aws.String(ps.URL)
+		}
+		o.UsePathStyle = ps.PathStyle
+		if ps.ChecksumCompat {
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	}), nil
+}
+
+// headAttrs reads the attributes of an object with HeadObject, for stores
+// without GetObjectAttributes. They carry no checksums.
+func (s *S3) headAttrs(bucket, prefix string) (*s3.GetObjectAttributesOutput, error) {
+	head, err := s.clientOf(bucket).HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(prefix),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectAttributesOutput{
+		ETag:         head.ETag,
+		LastModified: head.LastModified,
+		ObjectSize:   head.ContentLength,
+		StorageClass: types.StorageClass(head.StorageClass),
+	}, nil
+}
@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/smithy-go"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	// regionHint is the region asked first where a bucket is; S3 answers
+	// with the bucket's actual region from any of them.
+	regionHint = "ap-southeast-1"
+	// regionCacheTTL bounds how long a bucket's region is trusted from disk.
+	// A bucket only moves by being deleted and created again elsewhere, so
+	// this can be long.
+	regionCacheTTL = 7 * 24 * time.Hour
+	// maxRegionLen is longer than any region name, so a longer cache file is
+	// not one.
+	maxRegionLen = 64
+	// regionCachePerm lets other users sharing /tmp reuse the lookup; a
+	// bucket's region is no secret.
+	regionCachePerm = 0644
+)
+
+// lookupRegion asks S3 which region bucket is in. A var so tests can stand
+// in for S3.
+var lookupRegion = func(bucket string) (string, error) {
+	return s3manager.GetBucketRegion(context.Background(), session.Must(session.NewSession()), bucket, regionHint)
+}
+
+func regionCacheFile(bucket string) string {
+	return common.GenTempFileName("s3-region-", bucket)
+}
+
+// validRegion reports whether s looks like a region name, which keeps a
+// stray or truncated cache file from being used as one.
+func validRegion(s string) bool {
+	if s == "" || len(s) > maxRegionLen {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// readRegionCache returns the region cached for bucket, unless there is none
+// usable or it is older than regionCacheTTL.
+func readRegionCache(bucket string) (string, bool) {
+	f, err := os.Open(regionCacheFile(bucket))
+	if err != nil {
+		return "", false
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || time.Since(fi.ModTime()) > regionCacheTTL {
+		return "", false
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxRegionLen+1))
+	if err != nil || !validRegion(string(b)) {
+		return "", false
+	}
+	return string(b), true
+}
+
+func writeRegionCache(bucket, region string) {
+	if err := common.WriteFileAtomic(regionCacheFile(bucket), []byte(region), regionCachePerm); err != nil {
+		logger.Debug(module, "write region cache of bucket[%s] failed with %s", bucket, err)
+	}
+}
+
+// bucketRegion returns the region of bucket, from the cache on disk when a
+// recent run has looked it up. A failed lookup is an error rather than a
+// guess: a client in the wrong region fails every request with a 301.
+func bucketRegion(bucket string) (string, error) {
+	if region, ok := readRegionCache(bucket); ok {
+		return region, nil
+	}
+	region, err := lookupRegion(bucket)
+	if err != nil {
+		logger.Info(module, "looking up the region of bucket[%s] failed with %s", bucket, err)
+		return "", err
+	}
+	writeRegionCache(bucket, region)
+	return region, nil
+}
+
+// isRegionRedirect reports whether err is S3 saying that the bucket is not in
+// the region the request was sent to.
+func isRegionRedirect(err error) bool {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusMovedPermanently {
+		return true
+	}
+	var ae smithy.APIError
+	return errors.As(err, &ae) && ae.ErrorCode() == "PermanentRedirect"
+}
+
+// regionKey carries the region a redirected request is sent to again, which
+// regionalEndpoints resolves it in instead of the client's own.
+type regionKey struct{}
+
+// regionalEndpoints resolves the endpoint of a request in the region its
+// context carries, if any.
+type regionalEndpoints struct{ s3.EndpointResolverV2 }
+
+func (r regionalEndpoints) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	if region, ok := ctx.Value(regionKey{}).(string); ok {
+		params.Region = aws.String(region)
+	}
+	return r.EndpointResolverV2.ResolveEndpoint(ctx, params)
+}
+
+// regionRedirect calls moved with the bucket of every request S3 redirects
+// to another region, which means the region it was sent to, most likely
+// from the cache, is no longer the bucket's. moved returns the bucket's
+// region looked up afresh, and the request is sent there once more. A
+// request with a body is not: the first attempt has read it.
+func regionRedirect(moved func(bucket string) string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GsgRegionRedirect",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				out, md, err := next.HandleInitialize(ctx, in)
+				bucket := inputBucket(in.Parameters)
+				if bucket == "" || !isRegionRedirect(err) {
+					return out, md, err
+				}
+				if _, retried := ctx.Value(regionKey{}).(string); retried {
+					return out, md, err
+				}
+				if region := moved(bucket); region != "" && !inputHasBody(in.Parameters) {
+					return next.HandleInitialize(context.WithValue(ctx, regionKey{}, region), in)
+				}
+				return out, md, err
+			}), middleware.Before)
+	}
+}
+
+// relocate drops the cached region of bucket, which S3 has just refused, and
+// gives the bucket a client in the region looked up afresh, which it
+// returns, or "" when the lookup failed.
+func (s *S3) relocate(bucket string) string {
+	logger.Info(module, "bucket[%s] is not in the region it was looked up in, looking it up again", bucket)
+	_ = os.Remove(regionCacheFile(bucket))
+	s.mu.Lock()
+	delete(s.buckets, bucket)
+	s.mu.Unlock()
+	if err := s.Init(bucket); err != nil {
+		logger.Info(module, "relocating bucket[%s] failed with %s", bucket, err)
+		return ""
+	}
+	return s.clientOf(bucket).Options().Region
+}
@@ -0,0 +1,363 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestValidRegion(t *testing.T) {
+	for s, want := range map[string]bool{
+		"us-west-2": true, "ap-southeast-1": true,
+		"": false, "US-WEST-2": false, "us-west-2\n": false, "../x": false,
+	} {
+		if got := validRegion(s); got != want {
+			t.Errorf("validRegion(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+// fakeRegions answers region lookups from a map, counting them
+func fakeRegions(t *testing.T, regions map[string]string) *int {
+	lookups := 0
+	saved := lookupRegion
+	t.Cleanup(func() { lookupRegion = saved })
+	lookupRegion = func(bucket string) (string, error) {
+		lookups++
+		if r, ok := regions[bucket]; ok {
+			return r, nil
+		}
+		return "", errors.New("NotFound")
+	}
+	for bucket := range regions {
+		_ = os.Remove(regionCacheFile(bucket))
+		t.Cleanup(func() { _ = os.Remove(regionCacheFile(bucket)) })
+	}
+	return &lookups
+}
+
+func TestBucketRegion(t *testing.T) {
+	bucket := "gsg-test-region-" + time.Now().Format("150405.000000")
+	lookups := fakeRegions(t, map[string]string{bucket: "eu-central-1"})
+
+	for i := 0; i < 2; i++ {
+		if region, err := bucketRegion(bucket); err != nil || region != "eu-central-1" {
+			t.Errorf("bucketRegion = %q, %v", region, err)
+		}
+	}
+	// The second answer came from the cache on disk.
+	if *lookups != 1 {
+		t.Errorf("looked up %d times, want 1", *lookups)
+	}
+
+	// A stale entry is looked up again.
+	old := time.Now().Add(-2 * regionCacheTTL)
+	_ = os.Chtimes(regionCacheFile(bucket), old, old)
+	if _, ok := readRegionCache(bucket); ok {
+		t.Errorf("a stale cache entry was used")
+	}
+
+	if _, err := bucketRegion(bucket + "-missing"); err == nil {
+		t.Errorf("a failed lookup should fail, not guess a region")
+	}
+}
+
+func TestInitClientPerRegion(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	suffix := time.Now().Format("150405.000000")
+	a, b, c := "gsg-test-a-"+suffix, "gsg-test-b-"+suffix, "gsg-test-c-"+suffix
+	fakeRegions(t, map[string]string{a: "us-west-2", b: "ap-southeast-1", c: "us-west-2"})
+
+	s := &S3{}
+	if err := s.Init(a, b); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := s.Init(c); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if s.clientOf(a) == s.clientOf(b) {
+		t.Errorf("buckets in different regions share a client")
+	}
+	if s.clientOf(a) != s.clientOf(c) {
+		t.Errorf("buckets in one region should share its client")
+	}
+	if got := s.clientOf(b).Options().Region; got != "ap-southeast-1" {
+		t.Errorf("client of %s is in %s", b, got)
+	}
+}
+
+func TestProfileClients(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	fakeRegions(t, map[string]string{})
+
+	r2 := &Profile{Endpoint: Endpoint{URL: "https://account.r2.cloudflarestorage.com", Region: "auto", ChecksumCompat: true}}
+	minio := &Profile{
+		Endpoint:        Endpoint{URL: "http://localhost:9000", PathStyle: true},
+		AccessKeyID:     "minio-id",
+		SecretAccessKey: "minio-secret",
+		StorageClass:    "REDUCED_REDUNDANCY",
+	}
+	s := New("r2", r2)
+	s.SetBucketProfile("cache", minio)
+	if s.Scheme() != "r2" || (&S3{}).Scheme() != "s3" {
+		t.Errorf("schemes = %s, %s", s.Scheme(), (&S3{}).Scheme())
+	}
+	// No region is looked up for a store: the lookup above fails them all.
+	if err := s.Init("data", "cache"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	o := s.clientOf("data").Options()
+	if aws.ToString(o.BaseEndpoint) != r2.URL || o.Region != "auto" || o.UsePathStyle || !s.checksumCompat("data") {
+		t.Errorf("r2 client options = %+v", o)
+	}
+	o = s.clientOf("cache").Options()
+	if aws.ToString(o.BaseEndpoint) != minio.URL || o.Region != defaultEndpointRegion || !o.UsePathStyle || s.checksumCompat("cache") {
+		t.Errorf("minio client options = %+v", o)
+	}
+	if s.sameProfile("data", "cache") || !s.sameProfile("data", "other") {
+		t.Errorf("buckets of different profiles cannot be copied between server side")
+	}
+	creds, err := s.clientOf("cache").Options().Credentials.Retrieve(context.TODO())
+	if err != nil || creds.AccessKeyID != "minio-id" {
+		t.Errorf("minio credentials = %+v, %v", creds, err)
+	}
+	if creds, _ = s.clientOf("data").Options().Credentials.Retrieve(context.TODO()); creds.AccessKeyID != "id" {
+		t.Errorf("r2 credentials = %+v", creds)
+	}
+	if s.storageClass("cache") != "REDUCED_REDUNDANCY" || s.storageClass("data") != "" {
+		t.Errorf("storage classes = %q, %q", s.storageClass("cache"), s.storageClass("data"))
+	}
+}
+
+func TestAnonymous(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", dir+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", dir+"/credentials")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	public := &Profile{Endpoint: Endpoint{Region: "us-east-1"}}
+
+	// Without credentials, public buckets can still be read.
+	s := New("s3", public)
+	if err := s.Init("noaa-ghcn-pds"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if !aws.IsCredentialsProvider(s.cfgs[public].Credentials, aws.AnonymousCredentials{}) {
+		t.Errorf("client without credentials is not anonymous")
+	}
+
+	// With them, only when asked to.
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s = New("s3", public)
+	s.Anonymous = true
+	if err := s.Init("noaa-ghcn-pds"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if !aws.IsCredentialsProvider(s.cfgs[public].Credentials, aws.AnonymousCredentials{}) {
+		t.Errorf("--anonymous client is not anonymous")
+	}
+}
+
+func TestRequestPayer(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	payers := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payers[r.URL.Path] = r.Header.Get("X-Amz-Request-Payer")
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer srv.Close()
+
+	store := Endpoint{URL: srv.URL, PathStyle: true}
+	s := New("s3", &Profile{Endpoint: store})
+	s.SetBucketProfile("partner", &Profile{Endpoint: store, RequestPayer: true})
+	if err := s.Init("own", "partner"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	for _, bucket := range []string{"own", "partner"} {
+		if _, err := s.clientOf(bucket).HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("a.txt"),
+		}); err != nil {
+			t.Fatalf("HeadObject: %v", err)
+		}
+	}
+	if payers["/own/a.txt"] != "" || payers["/partner/a.txt"] != "requester" {
+		t.Errorf("request payer headers = %v", payers)
+	}
+
+	// Operations without the field are left alone.
+	setRequestPayer(&s3.ListBucketsInput{})
+	in := &s3.ListObjectsV2Input{}
+	setRequestPayer(in)
+	if in.RequestPayer != types.RequestPayerRequester {
+		t.Errorf("listing does not pay")
+	}
+}
+
+// A bucket recreated in another region redirects every request sent with
+// the cached one, until the cache is dropped and the region looked up again.
+// Init looks a bucket's region up without holding the lock other buckets'
+// clients are read under.
+func TestInitLooksUpUnlocked(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	bucket := "gsg-test-unlocked-" + time.Now().Format("150405.000000")
+	fakeRegions(t, map[string]string{bucket: "us-west-2"})
+	s := &S3{}
+	lookup := lookupRegion
+	lookupRegion = func(bucket string) (string, error) {
+		if !s.mu.TryLock() {
+			t.Errorf("the region is looked up holding the lock")
+		} else {
+			s.mu.Unlock()
+		}
+		return lookup(bucket)
+	}
+	if err := s.Init(bucket); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if got := s.clientOf(bucket).Options().Region; got != "us-west-2" {
+		t.Errorf("client is in %s", got)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	bucket := "gsg-test-moved-" + time.Now().Format("150405.000000")
+	regions := map[string]string{bucket: "us-west-2"}
+	lookups := fakeRegions(t, regions)
+
+	s := &S3{}
+	if err := s.Init(bucket); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	regions[bucket] = "eu-west-1"
+	if got := s.relocate(bucket); got != "eu-west-1" {
+		t.Errorf("relocate = %q", got)
+	}
+	if got := s.clientOf(bucket).Options().Region; got != "eu-west-1" {
+		t.Errorf("relocated client is in %s", got)
+	}
+	if region, _ := readRegionCache(bucket); region != "eu-west-1" || *lookups != 2 {
+		t.Errorf("cached region = %q after %d lookups", region, *lookups)
+	}
+}
+
+func TestIsRegionRedirect(t *testing.T) {
+	moved := &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusMovedPermanently}}}
+	if !isRegionRedirect(&smithy.OperationError{Err: moved}) {
+		t.Errorf("a 301 is a redirect")
+	}
+	if !isRegionRedirect(&smithy.GenericAPIError{Code: "PermanentRedirect"}) {
+		t.Errorf("PermanentRedirect is a redirect")
+	}
+	if isRegionRedirect(&smithy.GenericAPIError{Code: "NoSuchKey"}) || isRegionRedirect(nil) {
+		t.Errorf("other errors are not")
+	}
+}
+
+func TestRegionRedirect(t *testing.T) {
+	var regions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch {
+		case strings.Contains(auth, "/eu-west-1/s3/"):
+			regions = append(regions, "eu-west-1")
+		case strings.Contains(auth, "/us-west-2/s3/"):
+			regions = append(regions, "us-west-2")
+			w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+		default:
+			t.Errorf("unexpected Authorization %q", auth)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	newClient := func(moved func(bucket string) string) *s3.Client {
+		return s3.New(s3.Options{
+			Region:       "us-west-2",
+			BaseEndpoint: aws.String(srv.URL),
+			UsePathStyle: true,
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+			}),
+			APIOptions: []func(*middleware.Stack) error{regionRedirect(moved)},
+		}, func(o *s3.Options) { o.EndpointResolverV2 = regionalEndpoints{o.EndpointResolverV2} })
+	}
+	head := &s3.HeadObjectInput{Bucket: aws.String("moved"), Key: aws.String("a.txt")}
+
+	// The request is sent again, once, in the region the bucket moved to.
+	var moved []string
+	client := newClient(func(bucket string) string {
+		moved = append(moved, bucket)
+		return "eu-west-1"
+	})
+	if _, err := client.HeadObject(context.TODO(), head); err != nil {
+		t.Fatalf("the redirected request should succeed in the new region: %v", err)
+	}
+	if len(moved) != 1 || moved[0] != "moved" {
+		t.Errorf("moved = %v", moved)
+	}
+	if len(regions) != 2 || regions[0] != "us-west-2" || regions[1] != "eu-west-1" {
+		t.Errorf("requests went to %v", regions)
+	}
+
+	// Without a new region it fails as it was.
+	regions = nil
+	client = newClient(func(string) string { return "" })
+	if _, err := client.HeadObject(context.TODO(), head); err == nil {
+		t.Errorf("a redirect that cannot be relocated should fail the request")
+	}
+	if len(regions) != 1 {
+		t.Errorf("requests went to %v", regions)
+	}
+}
+
+func TestInputHasBody(t *testing.T) {
+	if inputHasBody(&s3.HeadObjectInput{}) || inputHasBody(&s3.PutObjectInput{}) {
+		t.Errorf("no body was given")
+	}
+	if !inputHasBody(&s3.PutObjectInput{Body: strings.NewReader("x")}) {
+		t.Errorf("a body was given")
+	}
+}
+
+// Credentials that are configured but fail to load are an error, not a
+// reason to go anonymous.
+func TestBrokenCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", dir+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", dir+"/credentials")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	if err := os.WriteFile(dir+"/config", []byte("[profile broken]\ncredential_process = false\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	broken := &Profile{Endpoint: Endpoint{Region: "us-east-1"}, AWSProfile: "broken"}
+	s := New("s3", broken)
+	if err := s.Init("noaa-ghcn-pds"); err == nil {
+		t.Errorf("a failing credential_process should fail Init")
+	}
+}
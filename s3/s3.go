@@ -4,13 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"strconv"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -22,11 +27,11 @@ import (
 	"google.golang.org/api/googleapi"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 const (
@@ -41,14 +46,43 @@ const (
 )
 
 type S3 struct {
-	// mu guards the lazy client, for the same reason as in the gcs backend:
+	// mu guards the lazy clients, for the same reason as in the gcs backend:
 	// one S3 is registered for the whole process and every worker goroutine
 	// calls Init.
-	mu     sync.Mutex
-	client *s3.Client
+	mu sync.Mutex
+	// cfgs holds the sdk config of each profile, clients a client per
+	// profile and region, and buckets the client of each bucket Init has
+	// been given, so that buckets in different regions, stores and accounts
+	// can be used together.
+	cfgs    map[*Profile]aws.Config
+	clients map[clientKey]*s3.Client
+	buckets map[string]*s3.Client
+	// scheme is what the backend is registered as, "s3" when empty. An
+	// S3-compatible store gets a scheme of its own, such as r2.
+	scheme string
+	// profile is how the backend reaches its buckets, nil for the AWS
+	// defaults, and bucketProfiles the buckets reached otherwise.
+	profile        *Profile
+	bucketProfiles map[string]*Profile
+	// sourceKeys are the customer keys SourceKey reads buckets with
+	sourceKeys map[string]string
+	// RequestPayer agrees to pay for requests to requester pays buckets, for
+	// every profile. Set before first use.
+	RequestPayer bool
+	// SSEKMSKeyID and SSECustomerKey are the keys, as in Profile, of every
+	// profile that sets none of its own. Set before first use.
+	SSEKMSKeyID    string
+	SSECustomerKey string
+	// Anonymous sends unsigned requests, even when there are credentials,
+	// which is all public buckets need to be listed and read. Set before
+	// first use.
+	Anonymous bool
 }
 
 func (s *S3) Scheme() string {
+	if s.scheme != "" {
+		return s.scheme
+	}
 	return "s3"
 }
 
@@ -56,6 +90,9 @@ type S3Attributes struct {
 	S3Attrs *s3.GetObjectAttributesOutput
 	Bucket  string
 	Prefix  string
+	// Listed is set when S3Attrs was filled in from a listing rather than
+	// fetched, and so has no checksums.
+	Listed bool
 }
 
 func (s *S3) toAttrs(attrs *S3Attributes) *system.Attrs {
@@ -65,19 +102,78 @@ func (s *S3) toAttrs(attrs *S3Attributes) *system.Attrs {
 	if attrs.S3Attrs == nil {
 		return nil
 	}
-	var crc32c uint64 = 0
-	if attrs.S3Attrs.Checksum != nil && attrs.S3Attrs.Checksum.ChecksumCRC32C != nil {
-		crc32c, _ = strconv.ParseUint(*attrs.S3Attrs.Checksum.ChecksumCRC32C, 10, 32)
-	}
 	var size int64 = 0
 	if attrs.S3Attrs.ObjectSize != nil {
 		size = *attrs.S3Attrs.ObjectSize
 	}
-	return &system.Attrs{
+	res := &system.Attrs{
 		Size:    size,
-		CRC32:   uint32(crc32c),
+		CRC32:   s3CRC32C(attrs.S3Attrs.Checksum),
 		ModTime: getR2ModificationTime(attrs),
+		SHA256:  s3SHA256(attrs.S3Attrs.Checksum),
+		MD5:     s3MD5(attrs.S3Attrs.ETag),
+		Version: strings.Trim(aws.ToString(attrs.S3Attrs.ETag), `"`),
+	}
+	if attrs.Bucket != "" && s.encrypted(attrs.Bucket) {
+		res.MD5 = nil
+	}
+	if attrs.Listed {
+		// Fetched once for both, and only when one is asked for: a listing
+		// of a million objects would otherwise cost a million more requests.
+		checksum := sync.OnceValue(func() *types.Checksum {
+			fetched, err := s.S3Attrs(attrs.Bucket, attrs.Prefix)
+			if err != nil || fetched == nil {
+				return nil
+			}
+			return fetched.S3Attrs.Checksum
+		})
+		res.CalcCRC32C = func() uint32 { return s3CRC32C(checksum()) }
+		res.CalcSHA256 = func() string { return s3SHA256(checksum()) }
+	}
+	return res
+}
+
+// s3CRC32C converts S3's base64 ChecksumCRC32C, the four bytes of the crc
+// big-endian, to the number used everywhere else, or 0 when the object has
+// none. A multipart crc of crcs fails to decode, as it carries a "-N" suffix.
+func s3CRC32C(checksum *types.Checksum) uint32 {
+	if checksum == nil || checksum.ChecksumCRC32C == nil {
+		return 0
+	}
+	b, err := base64.StdEncoding.DecodeString(*checksum.ChecksumCRC32C)
+	if err != nil || len(b) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// s3MD5 reads the md5 out of an ETag, which is the content's md5 only for an
+// object uploaded in one part and not encrypted with KMS or a customer key. A multipart ETag
+// carries a "-N" suffix and fails to decode, so it is reported as unknown.
+func s3MD5(etag *string) []byte {
+	if etag == nil {
+		return nil
 	}
+	b, err := hex.DecodeString(strings.Trim(*etag, `"`))
+	if err != nil || len(b) != md5.Size {
+		return nil
+	}
+	return b
+}
+
+// s3SHA256 converts S3's base64 ChecksumSHA256 to the hex form used
+// everywhere else, or "" when the object has none. A multipart checksum is a
+// checksum of part checksums, suffixed "-N", and is not the content's sha256,
+// so it is reported as unknown rather than as a value that can never match.
+func s3SHA256(checksum *types.Checksum) string {
+	if checksum == nil || checksum.ChecksumSHA256 == nil {
+		return ""
+	}
+	b, err := base64.StdEncoding.DecodeString(*checksum.ChecksumSHA256)
+	if err != nil || len(b) != sha256.Size {
+		return ""
+	}
+	return hex.EncodeToString(b)
 }
 
 func getR2ModificationTime(attrs *S3Attributes) time.Time {
@@ -115,11 +211,16 @@ func (s *S3) S3Attrs(bucket, prefix string) (*S3Attributes, error) {
 		return nil, nil
 	}
 	var attrs *s3.GetObjectAttributesOutput
-	if attrs, err = s.client.GetObjectAttributes(context.TODO(), &s3.GetObjectAttributesInput{
-		Bucket:           aws.String(bucket),
-		Key:              aws.String(prefix),
-		ObjectAttributes: oat.Values(),
-	}); err != nil {
+	if s.checksumCompat(bucket) {
+		attrs, err = s.headAttrs(bucket, prefix)
+	} else {
+		attrs, err = s.clientOf(bucket).GetObjectAttributes(context.TODO(), &s3.GetObjectAttributesInput{
+			Bucket:           aws.String(bucket),
+			Key:              aws.String(prefix),
+			ObjectAttributes: oat.Values(),
+		})
+	}
+	if err != nil {
 		logger.Debug(module, "failed with s3://%s/%s %s", bucket, prefix, err)
 		return nil, nil
 	}
@@ -160,14 +261,16 @@ func matchImmediateSubPath(prefix, path string) string {
 }
 */
 
-func (s *S3) listObjectsAndSubPaths(bucket, prefix string, recursive bool) ([]string, error) {
+// walkPages calls fn with each page of the listing under prefix as it
+// arrives. It stops at the first error fn returns.
+func (s *S3) walkPages(bucket, prefix string, recursive bool, fn func(lo *s3.ListObjectsV2Output) error) error {
 	var err error
 	if err = s.Init(bucket); err != nil {
-		return nil, err
+		return err
 	}
 	var ok bool
 	if ok, err = s.IsObject(bucket, prefix); err != nil {
-		return nil, err
+		return err
 	}
 	if !ok {
 		prefix = common.SetPrefixAsDirectory(prefix)
@@ -180,90 +283,75 @@ func (s *S3) listObjectsAndSubPaths(bucket, prefix string, recursive bool) ([]st
 	if !recursive {
 		li.Delimiter = aws.String("/")
 	}
-	var lo *s3.ListObjectsV2Output
-	objects := []types.Object{}
-	commonPrefixes := map[string]struct{}{}
-	index := 0
-	for {
-		if lo, err = s.client.ListObjectsV2(context.TODO(), &li); err != nil {
+	// Paged by continuation token rather than by StartAfter the last key: a
+	// page holding only common prefixes has no last key, and a common prefix
+	// is never repeated on a later page.
+	p := s3.NewListObjectsV2Paginator(s.clientOf(bucket), &li)
+	for p.HasMorePages() {
+		var lo *s3.ListObjectsV2Output
+		if lo, err = p.NextPage(context.TODO()); err != nil {
 			logger.Info(module, "get objects attributes failed with %s", err)
-			return nil, err
+			return err
 		}
-		if !recursive {
-			for _, cp := range lo.CommonPrefixes {
-				commonPrefixes[*cp.Prefix] = struct{}{}
-			}
-		}
-		if len(lo.Contents) == 0 {
-			break
+		if err = fn(lo); err != nil {
+			return err
 		}
-		index++
-		objects = append(objects, lo.Contents...)
-		li.StartAfter = objects[len(objects)-1].Key
 	}
+	return nil
+}
 
-	subPaths := []string{}
-
-	for _, o := range objects {
-		subPaths = append(subPaths, *o.Key)
-	}
-	if !recursive {
-		for cp := range commonPrefixes {
-			subPaths = append(subPaths, cp)
-		}
+// listedAttrs builds the attributes of a listed object from the listing
+// itself, which carries its size, ETag and last modification. The checksums
+// it lacks are fetched only if something asks for them.
+func listedAttrs(bucket string, o types.Object) *S3Attributes {
+	return &S3Attributes{
+		S3Attrs: &s3.GetObjectAttributesOutput{
+			ObjectSize:   o.Size,
+			ETag:         o.ETag,
+			LastModified: o.LastModified,
+		},
+		Bucket: bucket,
+		Prefix: aws.ToString(o.Key),
+		Listed: true,
 	}
-	return subPaths, nil
 }
 
-// maxAttrsInFlight caps concurrent GetObjectAttributes calls while listing.
-// It matches the default of the -c flag; batchAttrs has no access to the
-// worker pool, so it cannot follow that flag directly.
-const maxAttrsInFlight = 64
-
-func (s *S3) batchAttrs(bucket, prefix string, recursive bool) ([]*S3Attributes, error) {
-	var err error
-	var subPaths []string
-	if subPaths, err = s.listObjectsAndSubPaths(bucket, prefix, recursive); err != nil {
-		return nil, err
-	}
-	res := make([]*S3Attributes, len(subPaths))
-	errs := make([]error, len(subPaths))
-
-	// A sub-path ending in "/" is a common prefix rather than an object, so it
-	// needs no request and is filled in right here, exactly as before. Only the
-	// entries that actually cost a round trip go through the fan-out below.
-	// Sized for the common case, where most sub-paths are objects rather than
-	// common prefixes: this is the million-key path, and growing by doubling
-	// would copy it repeatedly.
-	fetch := make([]int, 0, len(subPaths))
-	for index, subPath := range subPaths {
-		if strings.HasSuffix(subPath, "/") {
-			res[index] = &S3Attributes{
+// walkAttrs calls fn with the attributes of everything under prefix, a page
+// at a time, so that at most one page is held in memory. No request is made
+// beyond the listing.
+func (s *S3) walkAttrs(bucket, prefix string, recursive bool, fn func(*S3Attributes) error) error {
+	return s.walkPages(bucket, prefix, recursive, func(lo *s3.ListObjectsV2Output) error {
+		for _, o := range lo.Contents {
+			if err := fn(listedAttrs(bucket, o)); err != nil {
+				return err
+			}
+		}
+		if recursive {
+			return nil
+		}
+		// A common prefix is not an object, and has nothing to read.
+		for _, cp := range lo.CommonPrefixes {
+			if err := fn(&S3Attributes{
 				S3Attrs: &s3.GetObjectAttributesOutput{},
 				Bucket:  bucket,
-				Prefix:  subPath,
+				Prefix:  aws.ToString(cp.Prefix),
+			}); err != nil {
+				return err
 			}
-			continue
 		}
-		fetch = append(fetch, index)
-	}
-
-	// One goroutine per object also meant one in-flight GetObjectAttributes
-	// call per object, so a prefix holding a million keys started a million of
-	// each at once. Bound both.
-	common.ParallelDo(len(fetch), maxAttrsInFlight, func(i int) {
-		index := fetch[i]
-		s3a, e := s.S3Attrs(bucket, subPaths[index])
-		res[index] = s3a
-		errs[index] = e
+		return nil
 	})
-	for _, e := range errs {
-		if e != nil {
-			return nil, e
-		}
+}
+
+func (s *S3) batchAttrs(bucket, prefix string, recursive bool) ([]*S3Attributes, error) {
+	res := []*S3Attributes{}
+	if err := s.walkAttrs(bucket, prefix, recursive, func(a *S3Attributes) error {
+		res = append(res, a)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 	return res, nil
-
 }
 
 // GetObjectsAttributes gets the attributes of all the objects under a prefix
@@ -283,26 +371,23 @@ func (s *S3) BatchAttributes(bucket, prefix string, recursive bool) ([]*system.A
 // List objects under a prefix
 func (s *S3) List(bucket, prefix string, recursive bool) ([]*system.FileObject, error) {
 	fos := []*system.FileObject{}
-	var err error
-	var s3as []*S3Attributes
-	if s3as, err = s.batchAttrs(bucket, prefix, recursive); err != nil {
-		return nil, err
-	}
-	for _, attr := range s3as {
-		fo := s.toFileObject(attr)
-		if fo == nil {
-			// batchAttrs leaves a nil entry when an object's attribute lookup
-			// failed. Callers dereference what List returns, so drop it here --
-			// but say so, because S3Attrs reports every failure as "not an
-			// object" and a dropped key would otherwise go unnoticed.
-			logger.Info(module, "skipping bucket[%s] prefix[%s]: attributes unavailable", bucket, prefix)
-			continue
-		}
+	if err := s.Walk(bucket, prefix, recursive, func(fo *system.FileObject) error {
 		fos = append(fos, fo)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 	return fos, nil
 }
 
+// Walk calls fn with each object under a prefix, a page of the listing at a
+// time, rather than after all of it as List does.
+func (s *S3) Walk(bucket, prefix string, recursive bool, fn func(*system.FileObject) error) error {
+	return system.EndWalk(s.walkAttrs(bucket, prefix, recursive, func(attr *S3Attributes) error {
+		return fn(s.toFileObject(attr))
+	}))
+}
+
 // s3ObjectSize reads an object's size, which is absent on directory markers and
 // on responses that carry no ObjectSize.
 func s3ObjectSize(attrs *S3Attributes) int64 {
@@ -327,18 +412,11 @@ func (s *S3) DiskUsage(bucket, prefix string, recursive bool) ([]system.DiskUsag
 	}
 	// is directory
 	root := system.NewDUTree(prefix, 0, true)
-	var objs []*S3Attributes
-	if objs, err = s.batchAttrs(bucket, prefix, recursive); err != nil {
-		return nil, err
-	}
-	for _, obj := range objs {
-		if obj == nil {
-			// Same as in List: S3Attrs reports every failure as "not an
-			// object", so an unreadable key would silently undercount the total.
-			logger.Info(module, "skipping an object under bucket[%s] prefix[%s]: attributes unavailable", bucket, prefix)
-			continue
-		}
+	if err = s.walkAttrs(bucket, prefix, recursive, func(obj *S3Attributes) error {
 		root.Add(obj.Prefix, s3ObjectSize(obj), prefix)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 	return root.ToDiskUsages(), nil
 }
@@ -347,7 +425,7 @@ func (s *S3) DeleteObject(bucket, prefix string) error {
 	if err = s.Init(bucket); err != nil {
 		return err
 	}
-	_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	_, err = s.clientOf(bucket).DeleteObject(context.TODO(), &s3.DeleteObjectInput{
 		Bucket: &bucket,
 		Key:    &prefix,
 	})
@@ -360,7 +438,7 @@ func (s *S3) Delete(bucket, prefix string) error {
 	if err = s.Init(bucket); err != nil {
 		return err
 	}
-	if _, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	if _, err = s.clientOf(bucket).DeleteObject(context.TODO(), &s3.DeleteObjectInput{
 		Bucket: &bucket,
 		Key:    &prefix,
 	}); err != nil {
@@ -371,88 +449,248 @@ func (s *S3) Delete(bucket, prefix string) error {
 	return nil
 }
 
-// CopyObject copies an object
+// maxDeleteObjects is the most keys one DeleteObjects request takes
+const maxDeleteObjects = 1000
+
+// DeleteObjects deletes objects a thousand per request. S3 reports the keys
+// it failed to delete one by one; a request that fails as a whole fails each
+// of its keys.
+func (s *S3) DeleteObjects(bucket string, prefixes []string) []error {
+	errs := make([]error, len(prefixes))
+	if err := s.Init(bucket); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for start := 0; start < len(prefixes); start += maxDeleteObjects {
+		end := min(start+maxDeleteObjects, len(prefixes))
+		ids := make([]types.ObjectIdentifier, 0, end-start)
+		index := make(map[string]int, end-start)
+		for i := start; i < end; i++ {
+			ids = append(ids, types.ObjectIdentifier{Key: aws.String(prefixes[i])})
+			index[prefixes[i]] = i
+		}
+		out, err := s.clientOf(bucket).DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			logger.Info(module, "delete objects in s3://%s failed with %s", bucket, err)
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+			continue
+		}
+		for _, e := range out.Errors {
+			if i, ok := index[aws.ToString(e.Key)]; ok {
+				errs[i] = fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+			}
+		}
+		logger.Info(module, "Removing %d objects from bucket[%s]", end-start-len(out.Errors), bucket)
+	}
+	return errs
+}
+
+// CopyObject copies an object, a part at a time if it has to
 func (s *S3) Copy(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
+	return s.CopyWithContext(srcBucket, srcPrefix, dstBucket, dstPrefix, system.RunContext{})
+}
+
+func (s *S3) PutObject(bucket, prefix string, from io.Reader) error {
 	var err error
-	if err = s.Init(srcBucket); err != nil {
+	if err = s.Init(bucket); err != nil {
 		return err
 	}
-	var s3a *S3Attributes
-	if s3a, err = s.S3Attrs(srcBucket, srcPrefix); err != nil {
+	if _, err = s.clientOf(bucket).PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(prefix),
+		Body:         from,
+		StorageClass: s.storageClass(bucket),
+	}); err != nil {
 		return err
 	}
-	// check object
-	if s3a == nil {
-		log := fmt.Sprintf("failed with bucket[%s] prefix[%s] not an object", srcBucket, srcPrefix)
-		logger.Debug(module, log)
-		return fmt.Errorf(log)
-	}
+	return nil
+}
 
-	if _, err = s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-		Bucket:     aws.String(dstBucket),
-		Key:        aws.String(dstPrefix),
-		CopySource: aws.String(fmt.Sprintf("%v/%v", srcBucket, srcPrefix)),
-	}); err != nil {
-		logger.Info(module, "copy object failed with %s", err)
-		return err
+// Init loads the configuration of each profile once, and makes sure each of
+// buckets has a client of its profile in its own region. The configuration
+// and the region can take requests to find, so they are looked up without
+// holding s.mu.
+func (s *S3) Init(buckets ...string) error {
+	s.mu.Lock()
+	if s.cfgs == nil {
+		if len(buckets) == 0 {
+			s.mu.Unlock()
+			common.Exit()
+			return fmt.Errorf("S3 initialization need target bucket")
+		}
+		s.cfgs = map[*Profile]aws.Config{}
+		s.clients = map[clientKey]*s3.Client{}
+		s.buckets = map[string]*s3.Client{}
+	}
+	s.mu.Unlock()
+	for _, bucket := range buckets {
+		if err := s.initBucket(bucket); err != nil {
+			return err
+		}
 	}
-	logger.Info(
-		module,
-		"Copying from bucket[%s] prefix[%s] to bucket[%s] prefix[%s]",
-		srcBucket, srcPrefix, dstBucket, dstPrefix,
-	)
 	return nil
 }
 
-func (s *S3) PutObject(bucket, prefix string, from io.Reader) error {
+// initBucket gives bucket a client, unless it has one
+func (s *S3) initBucket(bucket string) error {
+	s.mu.Lock()
+	if _, ok := s.buckets[bucket]; ok || bucket == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	p := s.profileOf(bucket)
+	ps := s.settingsOf(bucket)
+	cfg, loaded := s.cfgs[p]
+	s.mu.Unlock()
+
 	var err error
-	if err = s.Init(bucket); err != nil {
-		return err
+	if !loaded {
+		if cfg, err = loadConfig(ps); err != nil {
+			logger.Info(module, "failed in loading defaultConfig with error: %s", err)
+			common.Exit()
+			return err
+		}
 	}
-	if _, err = s.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(prefix),
-		Body:   from,
-	}); err != nil {
+	region, err := regionOf(ps, bucket)
+	if err != nil {
 		return err
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another caller may have got here first; what it stored stands.
+	if _, ok := s.buckets[bucket]; ok {
+		return nil
+	}
+	if c, ok := s.cfgs[p]; ok {
+		cfg = c
+	} else {
+		s.cfgs[p] = cfg
+	}
+	key := clientKey{profile: p, region: region, kmsKeyID: ps.SSEKMSKeyID, customerKey: ps.SSECustomerKey}
+	client, ok := s.clients[key]
+	if !ok {
+		if client, err = newClient(cfg, ps, region, s.relocate); err != nil {
+			logger.Info(module, "invalid encryption settings for bucket[%s]: %s", bucket, err)
+			return err
+		}
+		s.clients[key] = client
+	}
+	s.buckets[bucket] = client
 	return nil
 }
 
-func (s *S3) Init(buckets ...string) error {
+// clientOf returns the client of a bucket Init has been given
+func (s *S3) clientOf(bucket string) *s3.Client {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.client != nil {
-		return nil
+	return s.buckets[bucket]
+}
+
+// requestBucketKey carries a request's bucket from where its input is known
+// to where each attempt is sent
+type requestBucketKey struct{}
+
+// throttle makes every attempt of every request wait for common.Requests,
+// and reports each one S3 throttles. It is placed after the sdk's retries so
+// that retries after a SlowDown are paced like everything else. The bucket
+// is read off the input, which every S3 operation gsg uses names as Bucket.
+func throttle(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GsgRequestBucket",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			return next.HandleInitialize(middleware.WithStackValue(ctx, requestBucketKey{}, inputBucket(in.Parameters)), in)
+		}), middleware.Before); err != nil {
+		return err
+	}
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("GsgRequestLimit",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			bucket, _ := middleware.GetStackValue(ctx, requestBucketKey{}).(string)
+			if err := common.Requests.Wait(ctx, "s3", bucket, isWriteOperation(awsmiddleware.GetOperationName(ctx))); err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+			}
+			out, md, err := next.HandleFinalize(ctx, in)
+			var re *smithyhttp.ResponseError
+			if errors.As(err, &re) && common.IsThrottleStatus(re.HTTPStatusCode()) {
+				common.ReportThrottle()
+			}
+			return out, md, err
+		}), "Retry", middleware.After)
+}
+
+// inputBucket reads the Bucket field of an operation's input, or ""
+func inputBucket(params any) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
 	}
-	if len(buckets) == 0 {
-		common.Exit()
-		return fmt.Errorf("S3 initialization need target bucket")
+	f := v.FieldByName("Bucket")
+	if !f.IsValid() || f.Type() != reflect.TypeOf((*string)(nil)) || f.IsNil() {
+		return ""
 	}
+	return f.Elem().String()
+}
 
-	bucket := buckets[0]
+// inputHasBody reports whether an operation's input carries a Body to send
+func inputHasBody(params any) bool {
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	f := v.FieldByName("Body")
+	return f.IsValid() && f.Kind() == reflect.Interface && !f.IsNil()
+}
 
-	cfg, e1 := config.LoadDefaultConfig(context.TODO(), func(options *config.LoadOptions) error {
-		var region string
-		var err error
-		if region, err = s3manager.GetBucketRegion(context.Background(), session.Must(session.NewSession()), bucket, "ap-southeast-1"); err != nil {
-			return nil
-		}
-		options.Region = region
-		return nil
-	})
-	if e1 != nil {
-		logger.Info(module, "failed in loading defaultConfig with error: %s", e1)
-		common.Exit()
-		return e1
+// requestPayer has every request say that the requester pays for it, which
+// requester pays buckets refuse requests without. Other buckets ignore it.
+func requestPayer(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GsgRequestPayer",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			setRequestPayer(in.Parameters)
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// setRequestPayer sets the RequestPayer field of an operation's input, for
+// the operations that have one.
+func setRequestPayer(params any) {
+	setInputField(params, "RequestPayer", types.RequestPayerRequester)
+}
+
+// setInputField sets the field name of an operation's input to value, if the
+// operation has such a field.
+func setInputField(params any, name string, value any) {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return
 	}
-	// Check if credentials are valid
-	if _, err := cfg.Credentials.Retrieve(context.TODO()); err != nil {
-		return err
+	f := v.Elem().FieldByName(name)
+	if f.IsValid() && f.CanSet() && f.Type() == reflect.TypeOf(value) {
+		f.Set(reflect.ValueOf(value))
 	}
+}
 
-	s.client = s3.NewFromConfig(cfg)
-	return nil
+// isWriteOperation tells writes from reads by the operation's name: every
+// read S3 has is a Get, Head or List.
+func isWriteOperation(name string) bool {
+	for _, read := range []string{"Get", "Head", "List"} {
+		if strings.HasPrefix(name, read) {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *S3) GetObjectReader(bucket, prefix string) (io.ReadCloser, error) {
@@ -461,7 +699,7 @@ func (s *S3) GetObjectReader(bucket, prefix string) (io.ReadCloser, error) {
 		return nil, err
 	}
 	var goo *s3.GetObjectOutput
-	if goo, err = s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+	if goo, err = s.clientOf(bucket).GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(prefix)}); err != nil {
 		return nil, err
@@ -510,7 +748,7 @@ func (s *S3) Download(
 	logger.Debug(module, "Downloading [%s] with %d chunk(s), chunk size: %d bytes, total size: %d bytes", prefix, chunkNumber, chunkSize, size)
 
 	var pb *bar.ProgressBar
-	var wg sync.WaitGroup
+	chunks := ctx.Nested()
 	var once sync.Once
 	dstFileTemp := common.GetTempFile(dstFile)
 	for i := 0; i < chunkNumber; i++ {
@@ -522,11 +760,8 @@ func (s *S3) Download(
 			length = size - startByte
 		}
 
-		wg.Add(1)
-		ctx.Pool.AddWithDepth(1,
-			func() {
-				defer wg.Done()
-
+		chunks.Go(
+			func(context.Context) error {
 				// create folder and temp file if not exist
 				once.Do(func() {
 					pb = ctx.Bars.New(size, fmt.Sprintf("Downloading [%s]:", prefix))
@@ -544,10 +779,10 @@ func (s *S3) Download(
 				if forceChecksum {
 					gi.ChecksumMode = types.ChecksumModeEnabled
 				}
-				oo, oe := s.client.GetObject(context.TODO(), &gi)
+				oo, oe := s.clientOf(bucket).GetObject(context.TODO(), &gi)
 				if oe != nil {
 					logger.Info(module, "download object failed when create reader with %s", oe)
-					common.Exit()
+					return oe
 				}
 
 				// create write with offset and length of file
@@ -555,11 +790,15 @@ func (s *S3) Download(
 				_, se := fl.Seek(startByte, 0)
 				if se != nil {
 					logger.Info(module, "download object failed when seek for offset with %s", se)
-					common.Exit()
+					return se
 				}
 
 				defer func() { _ = fl.Close() }()
 
+				// Paced here, before either copy below, so --limit-rate holds
+				// whichever mode writes the chunk.
+				body := ctx.DownloadLimit.Reader(oo.Body)
+
 				// If gentle I/O mode, use throttled writer to reduce impact
 				if ctx.GentleIO {
 					logger.Debug(module, "Using gentle I/O mode with throttled writer for chunk at offset %d", startByte)
@@ -570,11 +809,11 @@ func (s *S3) Download(
 					totalWritten := int64(0)
 
 					for {
-						n, readErr := oo.Body.Read(buf)
+						n, readErr := body.Read(buf)
 						if n > 0 {
 							if _, writeErr := fl.Write(buf[:n]); writeErr != nil {
 								logger.Info(module, "download object failed when write: %s", writeErr)
-								common.Exit()
+								return writeErr
 							}
 							if _, writeErr := pb.Write(buf[:n]); writeErr != nil {
 								// Progress bar write error, non-fatal
@@ -592,7 +831,7 @@ func (s *S3) Download(
 						}
 						if readErr != nil {
 							logger.Info(module, "download object failed when read: %s", readErr)
-							common.Exit()
+							return readErr
 						}
 					}
 
@@ -603,22 +842,25 @@ func (s *S3) Download(
 					bufWriter := bufio.NewWriterSize(fl, 4*1024*1024)
 					defer func() { _ = bufWriter.Flush() }()
 
-					if _, we := io.Copy(io.MultiWriter(bufWriter, pb), oo.Body); we != nil {
+					if _, we := io.Copy(io.MultiWriter(bufWriter, pb), body); we != nil {
 						logger.Info(module, "download object failed when write to offet with %s", we)
-						common.Exit()
+						return we
 					}
 
 					if err := bufWriter.Flush(); err != nil {
 						logger.Info(module, "download object failed when flush buffer with %s", err)
-						common.Exit()
+						return err
 					}
 				}
+				return nil
 			},
 		)
 	}
 
 	// move back the temp file
-	wg.Wait()
+	if err = chunks.Wait(); err != nil {
+		return err
+	}
 
 	// sync temp file to disk before rename
 	if tmpFile, err := os.OpenFile(dstFileTemp, os.O_WRONLY, 0766); err == nil {
@@ -652,18 +894,161 @@ func (s *S3) Upload(srcFile, bucket, prefix string, ctx system.RunContext) error
 	// progress bar
 	//modTime := common.GetFileModificationTime(srcFile)
 	logger.Info(module, "uploading %s to %s/%s", srcFile, bucket, prefix)
+	pi := &s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(prefix),
+		Body:         f,
+		StorageClass: s.storageClass(bucket),
+	}
+	if ctx.SHA256 {
+		if err = withSHA256(pi, srcFile); err != nil {
+			return err
+		}
+	}
+	if ctx.UploadLimit != nil {
+		// A paced body cannot be rewound, so the sdk streams it instead of
+		// reading it ahead for a checksum, which would spend the tokens twice.
+		// It needs the length up front to do that.
+		pi.Body = ctx.UploadLimit.Reader(f)
+		pi.ContentLength = aws.Int64(common.GetFileSize(srcFile))
+	}
 	// upload file
-	if _, err = s.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(prefix),
-		Body:   f,
-	}); err != nil {
+	if _, err = s.clientOf(bucket).PutObject(context.TODO(), pi); err != nil {
 		logger.Info(module, "upload object failed when copy file with %s", err)
 		return err
 	}
 	return nil
 }
 
+// WriteObject stores a stream as an object. The stream cannot be rewound,
+// so its length is sent up front: S3 rejects a body that ends early, and a
+// request aborted by a failed read stores nothing. PutObject takes no more
+// than 5GB, so a stream longer than one part is uploaded in parts instead.
+func (s *S3) WriteObject(bucket, prefix string, r io.Reader, attrs *system.Attrs) error {
+	var err error
+	if err = s.Init(bucket); err != nil {
+		return err
+	}
+	if attrs.Size > minCopyPartSize {
+		err = s.multipartWrite(bucket, prefix, r, attrs, copyPartSize(attrs.Size))
+	} else {
+		_, err = s.clientOf(bucket).PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(prefix),
+			Body:          r,
+			ContentLength: aws.Int64(attrs.Size),
+			ContentType:   contentType(attrs),
+			Metadata:      attrs.Metadata,
+			StorageClass:  s.storageClass(bucket),
+		})
+	}
+	if err != nil {
+		logger.Info(module, "write object failed with %s", err)
+		return err
+	}
+	return nil
+}
+
+// contentType is the ContentType to store attrs' content under, nil to let S3
+// pick its default.
+func contentType(attrs *system.Attrs) *string {
+	if attrs.ContentType == "" {
+		return nil
+	}
+	return aws.String(attrs.ContentType)
+}
+
+// multipartWrite uploads a stream of attrs.Size in parts of partSize, each streamed
+// as it is read rather than buffered, so the parts go one after another. The
+// crc32c of the whole stream is computed on the way and handed to S3 with the
+// last request, which rejects the object if what it stored differs. Any
+// failure aborts the upload, so nothing is published.
+func (s *S3) multipartWrite(bucket, prefix string, r io.Reader, attrs *system.Attrs, partSize int64) error {
+	size := attrs.Size
+	client := s.clientOf(bucket)
+	mu, err := client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(prefix),
+		ContentType:       contentType(attrs),
+		Metadata:          attrs.Metadata,
+		StorageClass:      s.storageClass(bucket),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		ChecksumType:      types.ChecksumTypeFullObject,
+	})
+	if err != nil {
+		return err
+	}
+	digest := common.NewDigest(false)
+	r = io.TeeReader(r, digest)
+	parts := make([]types.CompletedPart, (size+partSize-1)/partSize)
+	for i := range parts {
+		n := min(partSize, size-int64(i)*partSize)
+		var out *s3.UploadPartOutput
+		if out, err = client.UploadPart(context.TODO(), &s3.UploadPartInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(prefix),
+			UploadId:          mu.UploadId,
+			PartNumber:        aws.Int32(int32(i + 1)),
+			Body:              io.LimitReader(r, n),
+			ContentLength:     aws.Int64(n),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		}); err != nil {
+			err = fmt.Errorf("part %d: %w", i+1, err)
+			break
+		}
+		parts[i] = types.CompletedPart{
+			PartNumber:     aws.Int32(int32(i + 1)),
+			ETag:           out.ETag,
+			ChecksumCRC32C: out.ChecksumCRC32C,
+		}
+	}
+	if err == nil {
+		crc := binary.BigEndian.AppendUint32(nil, digest.CRC32C())
+		_, err = client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(prefix),
+			UploadId:        mu.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+			ChecksumType:    types.ChecksumTypeFullObject,
+			ChecksumCRC32C:  aws.String(base64.StdEncoding.EncodeToString(crc)),
+		})
+	}
+	if err != nil {
+		if _, ae := client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(prefix),
+			UploadId: mu.UploadId,
+		}); ae != nil {
+			logger.Info(module, "abort multipart write to s3://%s/%s failed with %s", bucket, prefix, ae)
+		}
+		return err
+	}
+	return nil
+}
+
+// withSHA256 sets the file's sha256 on a PutObject request, both as custom
+// metadata and as S3's own ChecksumSHA256.
+//
+// Unlike GCS, S3 cannot patch metadata onto an object after the fact without
+// copying it, so the digest has to be known before the request is sent. It
+// comes from the same on-disk cache as rsync --checksum uses, so a file is
+// read once more at most. Sending it as ChecksumSHA256 makes S3 hash the
+// stream as it arrives and reject the upload if the two disagree, so the
+// value stored is verified against the bytes actually stored -- and it is
+// what GetObjectAttributes reads back, which user metadata is not.
+func withSHA256(pi *s3.PutObjectInput, srcFile string) error {
+	sum := common.GetFileSHA256(srcFile)
+	b, err := hex.DecodeString(sum)
+	if err != nil || len(b) != sha256.Size {
+		log := fmt.Sprintf("upload object failed when computing sha256 of %s", srcFile)
+		logger.Info(module, log)
+		return fmt.Errorf(log)
+	}
+	pi.Metadata = map[string]string{system.SHA256MetadataKey: sum}
+	pi.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(b))
+	return nil
+}
+
 // MoveObject moves an object
 func (s *S3) Move(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
 	if srcBucket == dstBucket && srcPrefix == dstPrefix {
@@ -687,7 +1072,7 @@ func (s *S3) Cat(bucket, prefix string) ([]byte, error) {
 		return nil, err
 	}
 	var o *s3.GetObjectOutput
-	if o, err = s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+	if o, err = s.clientOf(bucket).GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(prefix),
 	}); err != nil {
@@ -720,9 +1105,17 @@ func (s *S3) IsObject(bucket, prefix string) (bool, error) {
 
 // IsDirectory checks if is a directory
 func (s *S3) IsDirectory(bucket, prefix string) (bool, error) {
-	var err error
-	var objs []string
-	if objs, err = s.listObjectsAndSubPaths(bucket, prefix, true); err != nil {
+	// Two keys settle it, so there is no need to list the rest.
+	objs := []string{}
+	if err := system.EndWalk(s.walkPages(bucket, prefix, true, func(lo *s3.ListObjectsV2Output) error {
+		for _, o := range lo.Contents {
+			objs = append(objs, aws.ToString(o.Key))
+		}
+		if len(objs) > 1 {
+			return system.ErrStopWalk
+		}
+		return nil
+	})); err != nil {
 		return false, err
 	}
 	if len(objs) > 1 {
@@ -826,7 +1219,7 @@ func (s *S3) DoAttemptUnlock(bucket, object string, etag string) error {
 	// had just acquired, seconds after this caller's own lock expired. The gcs
 	// backend has always conditioned its delete on the generation it stored;
 	// this is the same guarantee.
-	_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	_, err = s.clientOf(bucket).DeleteObject(context.TODO(), &s3.DeleteObjectInput{
 		Bucket:  aws.String(bucket),
 		Key:     aws.String(object),
 		IfMatch: aws.String(etag),
@@ -860,7 +1253,7 @@ func (s *S3) DoAttemptLock(bucket, object string, ttl time.Duration) (string, er
 	// An existing lock is either still held, in which case we lose, or expired,
 	// in which case it is cleared out of the way -- conditionally, so that a
 	// lock someone else acquired between the read and the delete survives.
-	head, herr := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+	head, herr := s.clientOf(bucket).HeadObject(context.TODO(), &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(object),
 	})
@@ -873,7 +1266,7 @@ func (s *S3) DoAttemptLock(bucket, object string, ttl time.Duration) (string, er
 		if head.ETag != nil {
 			del.IfMatch = head.ETag
 		}
-		if _, derr := s.client.DeleteObject(context.TODO(), del); derr != nil {
+		if _, derr := s.clientOf(bucket).DeleteObject(context.TODO(), del); derr != nil {
 			// Someone else cleared or replaced it first. Theirs now.
 			logger.Debug(module, "DoAttemptLock: expired lock changed underneath us: %s", derr)
 			return "", fmt.Errorf("lock already exists and not expired")
@@ -893,7 +1286,7 @@ func (s *S3) DoAttemptLock(bucket, object string, ttl time.Duration) (string, er
 	// If-None-Match: * creates only when the key is absent, so exactly one of
 	// several contenders wins. Without it both the Head above and this Put were
 	// unconditional, and every contender came away believing it held the lock.
-	putOutput, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+	putOutput, err := s.clientOf(bucket).PutObject(context.TODO(), &s3.PutObjectInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(object),
 		Body:        strings.NewReader(token),
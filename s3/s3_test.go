@@ -1,6 +1,23 @@
 package s3
 
-import "testing"
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextbillion-ai/gsg/system"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
 
 func TestValidLockETag(t *testing.T) {
 	for _, c := range []struct {
@@ -22,3 +39,205 @@ func TestValidLockETag(t *testing.T) {
 		}
 	}
 }
+
+func TestS3CRC32C(t *testing.T) {
+	crc := "yZRlqg==" // crc32c of "hello world"
+	for _, c := range []struct {
+		checksum *types.Checksum
+		want     uint32
+	}{
+		{nil, 0},
+		{&types.Checksum{}, 0},
+		{&types.Checksum{ChecksumCRC32C: &crc}, 0xc99465aa},
+		// A multipart crc of crcs is not the content's crc.
+		{&types.Checksum{ChecksumCRC32C: aws.String(crc + "-2")}, 0},
+	} {
+		if got := s3CRC32C(c.checksum); got != c.want {
+			t.Errorf("s3CRC32C(%v) = %08x, want %08x", c.checksum, got, c.want)
+		}
+	}
+}
+
+func TestS3SHA256(t *testing.T) {
+	sum := "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	for _, c := range []struct {
+		checksum *types.Checksum
+		want     string
+	}{
+		{nil, ""},
+		{&types.Checksum{}, ""},
+		{&types.Checksum{ChecksumSHA256: &sum}, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		// A multipart checksum of checksums is not the content's sha256.
+		{&types.Checksum{ChecksumSHA256: aws.String(sum + "-2")}, ""},
+	} {
+		if got := s3SHA256(c.checksum); got != c.want {
+			t.Errorf("s3SHA256(%v) = %q, want %q", c.checksum, got, c.want)
+		}
+	}
+}
+
+func TestListedAttrs(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	attrs := (&S3{}).toAttrs(listedAttrs("b", types.Object{
+		Key:          aws.String("dir/f"),
+		Size:         aws.Int64(5),
+		ETag:         aws.String(`"5d41402abc4b2a76b9719d911017c592"`),
+		LastModified: &modTime,
+	}))
+	if attrs.Size != 5 || !attrs.ModTime.Equal(modTime) || attrs.Version != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("listed attrs = %+v", attrs)
+	}
+	if len(attrs.MD5) != 16 {
+		t.Errorf("md5 of a single part etag should be known, got %x", attrs.MD5)
+	}
+	// Checksums are not in a listing, and are left to be fetched on demand.
+	if attrs.CRC32 != 0 || attrs.SHA256 != "" || attrs.CalcCRC32C == nil || attrs.CalcSHA256 == nil {
+		t.Errorf("checksums of a listed object should be fetched lazily, got %+v", attrs)
+	}
+	if fetched := (&S3{}).toAttrs(&S3Attributes{S3Attrs: &s3.GetObjectAttributesOutput{}}); fetched.CalcCRC32C != nil {
+		t.Errorf("fetched attributes have nothing left to fetch")
+	}
+}
+
+func TestRequestClassification(t *testing.T) {
+	if got := inputBucket(&s3.ListObjectsV2Input{Bucket: aws.String("data")}); got != "data" {
+		t.Errorf("inputBucket(ListObjectsV2Input) = %q", got)
+	}
+	if got := inputBucket(&s3.PutObjectInput{}); got != "" {
+		t.Errorf("inputBucket with no bucket = %q", got)
+	}
+	if got := inputBucket(nil); got != "" {
+		t.Errorf("inputBucket(nil) = %q", got)
+	}
+	for op, write := range map[string]bool{
+		"GetObject": false, "HeadObject": false, "ListObjectsV2": false, "GetObjectAttributes": false,
+		"PutObject": true, "CopyObject": true, "DeleteObject": true, "DeleteObjects": true,
+	} {
+		if got := isWriteOperation(op); got != write {
+			t.Errorf("isWriteOperation(%s) = %t, want %t", op, got, write)
+		}
+	}
+}
+
+func TestCopyPartSize(t *testing.T) {
+	for _, c := range []struct{ size, want int64 }{
+		{6 << 30, minCopyPartSize},
+		// 5TiB, the largest object S3 stores, needs parts above 512MiB.
+		{5 << 40, 525 << 20},
+	} {
+		got := copyPartSize(c.size)
+		if got != c.want {
+			t.Errorf("copyPartSize(%d) = %d, want %d", c.size, got, c.want)
+		}
+		if parts := (c.size + got - 1) / got; parts > maxCopyParts {
+			t.Errorf("copyPartSize(%d) makes %d parts", c.size, parts)
+		}
+	}
+}
+
+func TestCopyChecksum(t *testing.T) {
+	crc := "yZRlqg=="
+	for _, c := range []struct {
+		head      s3.HeadObjectOutput
+		algorithm types.ChecksumAlgorithm
+		kind      types.ChecksumType
+		whole     bool
+	}{
+		{s3.HeadObjectOutput{}, "", "", false},
+		{s3.HeadObjectOutput{ChecksumCRC32C: &crc, ChecksumType: types.ChecksumTypeFullObject}, types.ChecksumAlgorithmCrc32c, types.ChecksumTypeFullObject, true},
+		// A composite crc is of the parts, and cannot verify the copy.
+		{s3.HeadObjectOutput{ChecksumCRC32C: aws.String(crc + "-3"), ChecksumType: types.ChecksumTypeComposite}, types.ChecksumAlgorithmCrc32c, types.ChecksumTypeFullObject, false},
+		{s3.HeadObjectOutput{ChecksumSHA256: aws.String("x")}, types.ChecksumAlgorithmSha256, types.ChecksumTypeComposite, false},
+	} {
+		algorithm, kind, whole := copyChecksum(&c.head)
+		if algorithm != c.algorithm || kind != c.kind || (whole != nil) != c.whole {
+			t.Errorf("copyChecksum(%+v) = %s, %s, %v", c.head, algorithm, kind, whole)
+		}
+	}
+}
+
+// tlsServer starts a test server the sdk trusts. Streams of unknown checksum
+// are sent with a trailing one, which the sdk only does over TLS.
+func tlsServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", ca)
+	return srv
+}
+
+func TestMultipartWrite(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var mu sync.Mutex
+	var parts []string
+	var completed http.Header
+	srv := tlsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		switch {
+		case q.Has("uploads"):
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>`))
+		case q.Has("partNumber"):
+			parts = append(parts, r.Header.Get("X-Amz-Decoded-Content-Length"))
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.Header().Set("ETag", `"e`+q.Get("partNumber")+`"`)
+		default:
+			completed = r.Header.Clone()
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"e-3"</ETag></CompleteMultipartUploadResult>`))
+		}
+	})
+
+	s := New("s3", &Profile{Endpoint: Endpoint{URL: srv.URL, PathStyle: true}})
+	if err := s.Init("big"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := s.multipartWrite("big", "a.txt", strings.NewReader("hello world"), &system.Attrs{Size: 11}, 4); err != nil {
+		t.Fatalf("multipartWrite: %v", err)
+	}
+	if strings.Join(parts, ",") != "4,4,3" {
+		t.Errorf("part sizes = %v", parts)
+	}
+	// crc32c of "hello world", of the whole object rather than its parts
+	if got := completed.Get("X-Amz-Checksum-Crc32c"); got != "yZRlqg==" {
+		t.Errorf("complete checksum = %q", got)
+	}
+}
+
+// A copy between profiles streams through gsg, and keeps the content type and
+// metadata CopyObject would have.
+func TestStreamCopy(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var put http.Header
+	srv := tlsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			put = r.Header.Clone()
+			_, _ = io.Copy(io.Discard, r.Body)
+		case r.URL.Query().Has("attributes"):
+			_, _ = w.Write([]byte(`<GetObjectAttributesResponse><ObjectSize>5</ObjectSize></GetObjectAttributesResponse>`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Amz-Meta-Gsg-Sha256", "2cf24dba")
+			w.Header().Set("Content-Length", "5")
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte("hello"))
+			}
+		}
+	})
+
+	store := Endpoint{URL: srv.URL, PathStyle: true}
+	s := New("s3", &Profile{Endpoint: store})
+	s.SetBucketProfile("dst", &Profile{Endpoint: store, StorageClass: "STANDARD_IA"})
+	if err := s.CopyWithContext("src", "a.txt", "dst", "a.txt", system.RunContext{}); err != nil {
+		t.Fatalf("CopyWithContext: %v", err)
+	}
+	if put == nil || put.Get("Content-Type") != "text/plain" || put.Get("X-Amz-Meta-Gsg-Sha256") != "2cf24dba" {
+		t.Errorf("put headers = %v", put)
+	}
+}
@@ -0,0 +1,183 @@
+package system
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/nextbillion-ai/gsg/common"
+	"github.com/nextbillion-ai/gsg/logger"
+)
+
+// fanOutWriter tees a stream into one pipe per destination. A destination
+// that fails closes its end of the pipe and is dropped, so the others carry
+// on; writes only fail once every destination has.
+type fanOutWriter struct {
+	pws  []*io.PipeWriter
+	errs []error
+}
+
+func (f *fanOutWriter) Write(p []byte) (int, error) {
+	var wg sync.WaitGroup
+	live := 0
+	for i, pw := range f.pws {
+		if f.errs[i] != nil {
+			continue
+		}
+		live++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pw.Write(p); err != nil {
+				f.errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	if live == 0 {
+		return 0, fmt.Errorf("every destination failed")
+	}
+	return len(p), nil
+}
+
+// urlOf names a file the way it is given on the command line
+func urlOf(fo *FileObject) string {
+	if !fo.Remote {
+		return fo.Prefix
+	}
+	return fo.GetFullPath()
+}
+
+// verifyWrite checks a written object against the size and checksums of the
+// stream it was written from. Each checksum is compared only when the
+// destination has one: a local file's crc32c is recomputed from disk, while
+// an s3 object has an md5 only when it was not uploaded in parts.
+func verifyWrite(attrs *Attrs, size int64, crc32c uint32, sum []byte) error {
+	if attrs == nil {
+		return fmt.Errorf("not found after writing")
+	}
+	if attrs.Size != size {
+		return fmt.Errorf("size %d != %d", attrs.Size, size)
+	}
+	if attrs.CalcCRC32C != nil {
+		attrs.CRC32 = attrs.CalcCRC32C()
+	}
+	if (attrs.CRC32 != 0 || size == 0) && attrs.CRC32 != crc32c {
+		return fmt.Errorf("crc32c %08x != %08x", attrs.CRC32, crc32c)
+	}
+	if attrs.MD5 != nil && !bytes.Equal(attrs.MD5, sum) {
+		return fmt.Errorf("md5 %x != %x", attrs.MD5, sum)
+	}
+	return nil
+}
+
+// FanOut copies src to every one of dsts while reading it only once, writing
+// to all of them in parallel. Each destination is then verified on its own
+// against the checksums of what was read. The returned errors are per
+// destination, in the order given: one failing leaves the others untouched.
+func FanOut(src *FileObject, dsts []*FileObject, ctx RunContext) []error {
+	errs := make([]error, len(dsts))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	reader, ok := src.System.(Reader)
+	if !ok {
+		return fail(fmt.Errorf("%s backend cannot stream objects", src.System.Scheme()))
+	}
+	attrs := src.Attributes
+	if attrs == nil {
+		var err error
+		if attrs, err = src.System.Attributes(src.Bucket, src.Prefix); err != nil {
+			return fail(err)
+		}
+		if attrs == nil {
+			return fail(fmt.Errorf("bucket[%s] prefix[%s] is not an object", src.Bucket, src.Prefix))
+		}
+	}
+	rc, err := reader.GetObjectReader(src.Bucket, src.Prefix)
+	if err != nil {
+		return fail(err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	tee := &fanOutWriter{pws: make([]*io.PipeWriter, len(dsts)), errs: make([]error, len(dsts))}
+	var wg sync.WaitGroup
+	for i, dst := range dsts {
+		writer, ok := dst.System.(Writer)
+		if !ok {
+			errs[i] = fmt.Errorf("%s backend cannot write streams", dst.System.Scheme())
+			tee.errs[i] = errs[i]
+			continue
+		}
+		pr, pw := io.Pipe()
+		tee.pws[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var r io.Reader = pr
+			if dst.Remote {
+				r = ctx.UploadLimit.Reader(pr)
+			}
+			errs[i] = writer.WriteObject(dst.Bucket, dst.Prefix, r, attrs)
+			// Unblocks the tee if the writer gave up without reading to EOF.
+			if errs[i] != nil {
+				_ = pr.CloseWithError(errs[i])
+			} else {
+				_ = pr.Close()
+			}
+		}()
+	}
+
+	digest := common.NewDigest(false)
+	var md5h hash.Hash = md5.New()
+	ws := []io.Writer{tee, digest, md5h}
+	if ctx.Bars != nil {
+		ws = append(ws, ctx.Bars.New(attrs.Size, fmt.Sprintf("Copying [%s]:", urlOf(src))))
+	}
+	var from io.Reader = rc
+	if src.Remote {
+		from = ctx.DownloadLimit.Reader(rc)
+	}
+	n, err := io.Copy(io.MultiWriter(ws...), from)
+	for _, pw := range tee.pws {
+		if pw == nil {
+			continue
+		}
+		// An error makes every writer abort rather than publish a short object.
+		if err != nil {
+			_ = pw.CloseWithError(err)
+		} else {
+			_ = pw.Close()
+		}
+	}
+	wg.Wait()
+
+	for i, dst := range dsts {
+		if errs[i] == nil && tee.errs[i] != nil {
+			errs[i] = tee.errs[i]
+		}
+		if errs[i] != nil {
+			continue
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		dstAttrs, e := dst.System.Attributes(dst.Bucket, dst.Prefix)
+		if e == nil {
+			e = verifyWrite(dstAttrs, n, digest.CRC32C(), md5h.Sum(nil))
+		}
+		if e != nil {
+			errs[i] = fmt.Errorf("verifying %s failed: %w", urlOf(dst), e)
+			continue
+		}
+		logger.Debug(module, "copied %s to %s, %d bytes, crc32c %08x", urlOf(src), urlOf(dst), n, digest.CRC32C())
+	}
+	return errs
+}
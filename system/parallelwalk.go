@@ -0,0 +1,168 @@
+package system
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nextbillion-ai/gsg/common"
+)
+
+const (
+	// maxSplitDepth bounds how many levels of sub-prefixes ParallelWalk looks
+	// through for shards. Each level costs a delimited listing of every shard
+	// found on the level before.
+	maxSplitDepth = 3
+	// shardsPerWorker is how many shards per worker are enough to stop
+	// splitting: shards differ in size, and more of them than workers keeps
+	// every worker busy until the end.
+	shardsPerWorker = 4
+)
+
+// walkItem is one entry of a split prefix: a shard still to be walked, or an
+// object found while splitting.
+type walkItem struct {
+	key string
+	obj *FileObject
+}
+
+// ParallelWalk is Walk for a remote prefix too large to list page by page in
+// reasonable time. The prefix is split into shards by its sub-prefixes, found
+// with delimited listings a few levels down, and up to parallelism shards are
+// listed at once. fn is still called in the order Walk would call it, a shard
+// at a time once it has been listed whole, so up to parallelism shards are
+// held in memory. A prefix without sub-prefixes gains nothing, and local
+// trees and non-recursive walks are walked as usual.
+func ParallelWalk(sys ISystem, bucket, prefix string, recursive bool, parallelism int, fn func(*FileObject) error) error {
+	if !recursive || parallelism <= 1 || sys.Scheme() == "" {
+		return sys.Walk(bucket, prefix, recursive, fn)
+	}
+	ok, err := sys.IsObject(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return sys.Walk(bucket, prefix, recursive, fn)
+	}
+	items, err := splitPrefix(sys, bucket, common.SetPrefixAsDirectory(prefix), parallelism)
+	if err != nil {
+		return err
+	}
+	return walkShards(sys, bucket, items, parallelism, fn)
+}
+
+// splitPrefix replaces prefix, level by level, with what a delimited listing
+// of it finds: objects, and sub-prefixes as shards. It stops once there are
+// enough shards, or nothing left to split.
+func splitPrefix(sys ISystem, bucket, prefix string, parallelism int) ([]walkItem, error) {
+	items := []walkItem{{key: prefix}}
+	for depth := 0; depth < maxSplitDepth; depth++ {
+		shards := []int{}
+		for i, item := range items {
+			if item.obj == nil {
+				shards = append(shards, i)
+			}
+		}
+		if len(shards) == 0 || len(shards) >= parallelism*shardsPerWorker {
+			break
+		}
+		children := make([][]walkItem, len(shards))
+		errs := make([]error, len(shards))
+		common.ParallelDo(len(shards), parallelism, func(i int) {
+			children[i], errs[i] = splitShard(sys, bucket, items[shards[i]].key)
+		})
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		next := make([]walkItem, 0, len(items))
+		for i, item := range items {
+			if len(shards) > 0 && shards[0] == i {
+				next = append(next, children[0]...)
+				shards, children = shards[1:], children[1:]
+				continue
+			}
+			next = append(next, item)
+		}
+		items = next
+	}
+	return items, nil
+}
+
+// splitShard lists one level under prefix, in key order. Every key under a
+// sub-prefix sorts after it and before the next entry, so walking the
+// entries in this order lists the keys in the order a recursive listing would.
+func splitShard(sys ISystem, bucket, prefix string) ([]walkItem, error) {
+	fos, err := sys.List(bucket, prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]walkItem, 0, len(fos))
+	for _, fo := range fos {
+		// A placeholder object named like the prefix itself is listed too;
+		// it is an object here, and splitting it again would never end.
+		if strings.HasSuffix(fo.Prefix, "/") && fo.Prefix != prefix {
+			items = append(items, walkItem{key: fo.Prefix})
+			continue
+		}
+		items = append(items, walkItem{key: fo.Prefix, obj: fo})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	return items, nil
+}
+
+// walkShards lists the shards among items, up to parallelism at once, and
+// calls fn with everything in order. A shard's slot is freed only once fn
+// has been given it, which is what bounds memory.
+func walkShards(sys ISystem, bucket string, items []walkItem, parallelism int, fn func(*FileObject) error) error {
+	type listing struct {
+		fos []*FileObject
+		err error
+	}
+	results := make([]chan listing, len(items))
+	for i := range results {
+		results[i] = make(chan listing, 1)
+	}
+	slots := make(chan struct{}, parallelism)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i, item := range items {
+			if item.obj != nil {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func() {
+				fos := []*FileObject{}
+				err := sys.Walk(bucket, item.key, true, func(fo *FileObject) error {
+					fos = append(fos, fo)
+					return nil
+				})
+				results[i] <- listing{fos: fos, err: err}
+			}()
+		}
+	}()
+
+	for i, item := range items {
+		if item.obj != nil {
+			if err := fn(item.obj); err != nil {
+				return EndWalk(err)
+			}
+			continue
+		}
+		l := <-results[i]
+		<-slots
+		if l.err != nil {
+			return l.err
+		}
+		for _, fo := range l.fos {
+			if err := fn(fo); err != nil {
+				return EndWalk(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keyspace is a bucket listed the way the remote backends list one. Only what
+// ParallelWalk calls is implemented.
+type keyspace struct {
+	ISystem
+	keys []string
+}
+
+func (k *keyspace) Scheme() string { return "mem" }
+
+func (k *keyspace) IsObject(_, prefix string) (bool, error) {
+	i := sort.SearchStrings(k.keys, prefix)
+	return prefix != "" && i < len(k.keys) && k.keys[i] == prefix, nil
+}
+
+func (k *keyspace) Walk(_, prefix string, recursive bool, fn func(*FileObject) error) error {
+	seen := map[string]bool{}
+	for _, key := range k.keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !recursive {
+			if i := strings.Index(key[len(prefix):], "/"); i >= 0 && len(prefix)+i+1 < len(key) {
+				key = key[:len(prefix)+i+1]
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		if err := fn(&FileObject{System: k, Prefix: key, Remote: true}); err != nil {
+			return EndWalk(err)
+		}
+	}
+	return nil
+}
+
+func (k *keyspace) List(bucket, prefix string, recursive bool) ([]*FileObject, error) {
+	fos := []*FileObject{}
+	err := k.Walk(bucket, prefix, recursive, func(fo *FileObject) error {
+		fos = append(fos, fo)
+		return nil
+	})
+	return fos, err
+}
+
+func walked(t *testing.T, walk func(fn func(*FileObject) error) error) []string {
+	t.Helper()
+	out := []string{}
+	assert.NoError(t, walk(func(fo *FileObject) error {
+		out = append(out, fo.Prefix)
+		return nil
+	}))
+	return out
+}
+
+func TestParallelWalk(t *testing.T) {
+	keys := []string{"data/", "data/a", "data/b/", "data/b-c", "data/top"}
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("data/b/%02d/x", i), fmt.Sprintf("data/c/%02d", i))
+	}
+	keys = append(keys, "data/b/deep/er/est/f", "database", "other/z")
+	sort.Strings(keys)
+	k := &keyspace{keys: keys}
+
+	want := walked(t, func(fn func(*FileObject) error) error { return k.Walk("", "data/", true, fn) })
+	for _, n := range []int{2, 4, 64} {
+		got := walked(t, func(fn func(*FileObject) error) error { return ParallelWalk(k, "", "data", true, n, fn) })
+		assert.Equal(t, want, got, "parallelism %d", n)
+	}
+
+	// Stopped early, with and without an error.
+	count := 0
+	assert.NoError(t, ParallelWalk(k, "", "data", true, 4, func(*FileObject) error {
+		count++
+		if count == 10 {
+			return ErrStopWalk
+		}
+		return nil
+	}))
+	assert.Equal(t, 10, count)
+	failed := errors.New("failed")
+	assert.Equal(t, failed, ParallelWalk(k, "", "data", true, 4, func(*FileObject) error { return failed }))
+}
@@ -1,7 +1,10 @@
 package system
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -18,11 +21,17 @@ const (
 	FileType_Object
 	FileType_Directory
 	module = "system"
+	// SHA256MetadataKey is the custom metadata key an object's hex sha256 is
+	// stored under when it was uploaded with --sha256.
+	SHA256MetadataKey = "gsg-sha256"
 )
 
 var (
 	_systems          = map[string]ISystem{}
 	ErrObjectNotFound = fmt.Errorf("Object Not Found")
+	// ErrStopWalk is returned by a Walk callback to end the walk early. Walk
+	// itself then returns nil.
+	ErrStopWalk = fmt.Errorf("stop walk")
 )
 
 func Register(system ISystem) {
@@ -39,6 +48,58 @@ type Attrs struct {
 	ModTime      time.Time
 	RelativePath string
 	CalcCRC32C   func() uint32
+	// SHA256 is the hex sha256 of the content, or "" when it is not known:
+	// objects only carry one when they were uploaded with --sha256.
+	SHA256     string
+	CalcSHA256 func() string
+	// MD5 is the content's md5 as the backend reports it, or nil when it does
+	// not: composite GCS objects and multipart S3 uploads have none.
+	MD5 []byte
+	// Version identifies this particular write of an object: the generation on
+	// GCS and the ETag on S3. Empty for local files.
+	Version string
+	// ContentType and Metadata are an object's content type and custom
+	// metadata, which WriteObject gives the copy. Only GCS reports them with
+	// the rest; S3 needs a HeadObject, and local files have neither.
+	ContentType string
+	Metadata    map[string]string
+}
+
+// GetCRC32C returns the crc32c of the content, computing it if a backend
+// left a way to.
+func (a *Attrs) GetCRC32C() uint32 {
+	if a.CalcCRC32C != nil {
+		a.CRC32 = a.CalcCRC32C()
+		a.CalcCRC32C = nil
+	}
+	return a.CRC32
+}
+
+// GetSHA256 returns the sha256 of the content, computing it if a backend left
+// a way to, and "" when it is not known.
+func (a *Attrs) GetSHA256() string {
+	if a.SHA256 == "" && a.CalcSHA256 != nil {
+		a.SHA256 = a.CalcSHA256()
+	}
+	return a.SHA256
+}
+
+// SameSHA256 compares content by sha256. The second result reports whether
+// the comparison was possible at all, i.e. both sides know their sha256; when
+// it is false the first result means nothing and callers fall back to Same.
+//
+// This is what makes a comparison across clouds possible: a gs object always
+// has a crc32c, but an s3 object only has one when it was uploaded asking for
+// it, while both carry a sha256 once uploaded with --sha256.
+func (a *Attrs) SameSHA256(b *Attrs) (bool, bool) {
+	if b == nil {
+		return false, false
+	}
+	as, bs := a.GetSHA256(), b.GetSHA256()
+	if as == "" || bs == "" {
+		return false, false
+	}
+	return a.Size == b.Size && as == bs, true
 }
 
 func (a *Attrs) Same(b *Attrs, forceChecksum bool) bool {
@@ -51,6 +112,11 @@ func (a *Attrs) Same(b *Attrs, forceChecksum bool) bool {
 	if !forceChecksum && !a.ModTime.Equal(time.Time{}) && !b.ModTime.Equal(time.Time{}) {
 		r = r && a.ModTime.Equal(b.ModTime)
 	}
+	// Checksums can cost a read or a request, so they are not computed for
+	// files already known to differ.
+	if !r {
+		return false
+	}
 	if a.CalcCRC32C != nil {
 		a.CRC32 = a.CalcCRC32C()
 	}
@@ -63,9 +129,29 @@ func (a *Attrs) Same(b *Attrs, forceChecksum bool) bool {
 
 type RunContext struct {
 	Bars      *bar.Container
-	Pool      *worker.Pool
 	ChunkSize int64
 	GentleIO  bool
+	// Group, when set, is the group the transfer runs as a job of. Its
+	// nested work, such as the chunks of a download or the parts of a copy,
+	// goes to the group's Child, and otherwise runs inline.
+	Group *worker.Group
+	// SHA256 asks Upload to compute the content's sha256 and store it as
+	// custom metadata under SHA256MetadataKey.
+	SHA256 bool
+	// UploadLimit and DownloadLimit pace the bytes sent and received, each
+	// one token bucket shared by every transfer and chunk given it. Nil is
+	// unlimited.
+	UploadLimit   *common.ScheduledLimiter
+	DownloadLimit *common.ScheduledLimiter
+}
+
+// Nested returns the group for the nested work of a transfer run with ctx,
+// the first of whose jobs to fail cancels the rest.
+func (ctx RunContext) Nested() *worker.Group {
+	if ctx.Group == nil {
+		return worker.Inline(context.Background()).FailFast()
+	}
+	return ctx.Group.Child().FailFast()
 }
 
 type DiskUsage struct {
@@ -79,6 +165,10 @@ type ISystem interface {
 	Attributes(bucket, prefix string) (*Attrs, error)
 	BatchAttributes(bucket, prefix string, recursive bool) ([]*Attrs, error)
 	List(bucket, prefix string, recursive bool) ([]*FileObject, error)
+	// Walk calls fn with every file object List would return, in the same
+	// order, as the listing arrives page by page, so memory stays bounded
+	// however many there are. It stops at the first error fn returns.
+	Walk(bucket, prefix string, recursive bool, fn func(*FileObject) error) error
 	DiskUsage(bucket, prefix string, recursive bool) ([]DiskUsage, error)
 	Delete(bucket, prefix string) error
 	Copy(srcBucket, srcPrefix, dstBucket, dstPrefix string) error
@@ -90,6 +180,43 @@ type ISystem interface {
 	IsDirectory(bucket, prefix string) (bool, error)
 }
 
+// Reader is implemented by backends that can stream an object's content.
+// Not every command needs it, so it is kept out of ISystem.
+type Reader interface {
+	GetObjectReader(bucket, prefix string) (io.ReadCloser, error)
+}
+
+// Writer is implemented by backends that can store an object from a stream.
+// WriteObject stores everything read from r as one object, and publishes
+// nothing if r fails before EOF. attrs are the source's: r is attrs.Size
+// bytes long, and attrs.ModTime is kept wherever Upload and Download keep a
+// file's mtime, so a later rsync sees the copy as current.
+type Writer interface {
+	WriteObject(bucket, prefix string, r io.Reader, attrs *Attrs) error
+}
+
+// EndWalk is the error a Walk returns, given the error that ended it
+func EndWalk(err error) error {
+	if errors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// BatchDeleter is implemented by backends that can delete many objects per
+// request. DeleteObjects takes any number, splitting them into as many
+// requests as it needs, and returns one error per object, in order, nil for
+// each one deleted.
+type BatchDeleter interface {
+	DeleteObjects(bucket string, prefixes []string) []error
+}
+
+// RunCopier is implemented by backends whose server-side copy can spread an
+// object too large to copy in one request over the pool, and show progress.
+type RunCopier interface {
+	CopyWithContext(srcBucket, srcPrefix, dstBucket, dstPrefix string, ctx RunContext) error
+}
+
 type FileObject struct {
 	System     ISystem
 	Bucket     string
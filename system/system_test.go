@@ -0,0 +1,43 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSameSHA256(t *testing.T) {
+	calls := 0
+	local := &Attrs{Size: 5, CalcSHA256: func() string { calls++; return "abc" }}
+	remote := &Attrs{Size: 5, SHA256: "abc"}
+
+	same, ok := local.SameSHA256(remote)
+	assert.True(t, ok)
+	assert.True(t, same)
+	// Computed once and then remembered.
+	_, _ = local.SameSHA256(remote)
+	assert.Equal(t, 1, calls)
+
+	same, ok = local.SameSHA256(&Attrs{Size: 5, SHA256: "def"})
+	assert.True(t, ok)
+	assert.False(t, same)
+
+	// A side without a sha256 cannot be compared at all.
+	_, ok = local.SameSHA256(&Attrs{Size: 5})
+	assert.False(t, ok)
+	_, ok = local.SameSHA256(nil)
+	assert.False(t, ok)
+}
+
+func TestVerifyWrite(t *testing.T) {
+	sum := []byte{1, 2, 3}
+	assert.NoError(t, verifyWrite(&Attrs{Size: 5, CRC32: 7, MD5: sum}, 5, 7, sum))
+	assert.Error(t, verifyWrite(nil, 5, 7, sum))
+	assert.Error(t, verifyWrite(&Attrs{Size: 4, CRC32: 7}, 5, 7, sum))
+	assert.Error(t, verifyWrite(&Attrs{Size: 5, CRC32: 8}, 5, 7, sum))
+	assert.Error(t, verifyWrite(&Attrs{Size: 5, CRC32: 7, MD5: []byte{9}}, 5, 7, sum))
+	// Nothing to compare but the size, as for an s3 object uploaded in parts.
+	assert.NoError(t, verifyWrite(&Attrs{Size: 5}, 5, 7, sum))
+	// A local file's crc32c is computed on demand.
+	assert.Error(t, verifyWrite(&Attrs{Size: 5, CalcCRC32C: func() uint32 { return 8 }}, 5, 7, sum))
+}
@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// gate caps how many of a depth's workers run a job at once. Every worker is
+// started up front, as before; the gate is what lets the number actually
+// working change while the pool runs.
+type gate struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	active    int
+	saturated bool
+}
+
+func newGate(limit int) *gate {
+	g := &gate{limit: limit}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *gate) acquire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.limit {
+		g.saturated = true
+		g.cond.Wait()
+	}
+	g.active++
+	if g.active == g.limit {
+		g.saturated = true
+	}
+}
+
+func (g *gate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	g.cond.Signal()
+}
+
+func (g *gate) setLimit(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = n
+	g.cond.Broadcast()
+}
+
+// takeSaturated reports whether every allowed worker was busy at some point
+// since the last call.
+func (g *gate) takeSaturated() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.saturated
+	g.saturated = g.active >= g.limit
+	return s
+}
+
+// AIMD chooses a pool's concurrency from what the last interval achieved, in
+// the additive increase, multiplicative decrease style of TCP congestion
+// control: any throttling halves it, and while every worker is busy it grows
+// for as long as growing still raises throughput. An increase that did not
+// pay is undone, and probing pauses for a while before trying again.
+type AIMD struct {
+	Min, Max int
+	// Hold is how many intervals to wait after an increase that did not pay.
+	Hold int
+
+	prevRate  float64
+	increased bool
+	holding   int
+}
+
+// Next returns the limit to use for the next interval, given the current one,
+// the bytes per second moved in the last, whether any request was throttled,
+// and whether the pool had more work than workers allowed.
+func (a *AIMD) Next(limit int, rate float64, throttled, saturated bool) int {
+	prev := a.prevRate
+	a.prevRate = rate
+	if throttled {
+		a.increased = false
+		a.holding = a.Hold
+		return a.clamp(limit / 2)
+	}
+	if !saturated {
+		// Idle workers say nothing about what more of them would do.
+		a.increased = false
+		return limit
+	}
+	if a.increased && rate < prev*1.05 {
+		a.increased = false
+		a.holding = a.Hold
+		return a.clamp(limit - a.step(limit))
+	}
+	if a.holding > 0 {
+		a.holding--
+		return limit
+	}
+	next := a.clamp(limit + a.step(limit))
+	a.increased = next > limit
+	return next
+}
+
+// step grows with the limit, so that reaching a thousand workers on a fast
+// link takes minutes rather than hours, while a laptop moves one at a time.
+func (a *AIMD) step(limit int) int {
+	if s := limit / 4; s > 1 {
+		return s
+	}
+	return 1
+}
+
+func (a *AIMD) clamp(n int) int {
+	if n < a.Min {
+		return a.Min
+	}
+	if n > a.Max {
+		return a.Max
+	}
+	return n
+}
+
+// Adapt adjusts the pool's concurrency with a every interval until the pool
+// is closed. transferred returns the total bytes moved so far and throttles
+// the total requests throttled, both as running counters. onChange, if not
+// nil, is told each new limit.
+func (p *Pool) Adapt(a *AIMD, interval time.Duration, transferred, throttles func() int64, onChange func(int)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastBytes, lastThrottles := transferred(), throttles()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+			bytes, throttled := transferred(), throttles()
+			rate := float64(bytes-lastBytes) / interval.Seconds()
+			limit := p.Limit()
+			next := a.Next(limit, rate, throttled > lastThrottles, p.takeSaturated())
+			lastBytes, lastThrottles = bytes, throttled
+			if next != limit {
+				p.SetLimit(next)
+				if onChange != nil {
+					onChange(next)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAIMD(t *testing.T) {
+	a := &AIMD{Min: 1, Max: 100, Hold: 2}
+	// Busy and getting faster: grow, by a quarter once that is more than one.
+	assert.Equal(t, 5, a.Next(4, 100, false, true))
+	assert.Equal(t, 6, a.Next(5, 200, false, true))
+	assert.Equal(t, 10, a.Next(8, 300, false, true))
+	// Growing stopped paying: step back, then hold before probing again.
+	assert.Equal(t, 8, a.Next(10, 301, false, true))
+	assert.Equal(t, 8, a.Next(8, 301, false, true))
+	assert.Equal(t, 8, a.Next(8, 301, false, true))
+	assert.Equal(t, 10, a.Next(8, 301, false, true))
+	// Idle workers: no change.
+	assert.Equal(t, 10, a.Next(10, 50, false, false))
+	// Throttled: halve, whatever the throughput.
+	assert.Equal(t, 5, a.Next(10, 1000, true, true))
+	assert.Equal(t, 1, a.Next(1, 1000, true, true), "never below Min")
+	assert.Equal(t, 100, (&AIMD{Min: 1, Max: 100}).Next(100, 1000, false, true), "never above Max")
+}
+
+func TestPoolLimit(t *testing.T) {
+	pool := New(8, false)
+	pool.Run()
+	pool.SetLimit(2)
+	assert.Equal(t, 2, pool.Limit())
+
+	var running, most atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		pool.Add(func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), most.Load(), "eight workers, but only two at once")
+	assert.True(t, pool.takeSaturated())
+
+	pool.SetLimit(1000)
+	assert.Equal(t, 8, pool.Limit(), "no more than the pool has")
+	pool.Close()
+}
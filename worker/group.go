@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Job is a unit of work run by a Group. It should give up once ctx is done.
+type Job func(ctx context.Context) error
+
+// Group is a set of jobs submitted to a pool at one depth, which the
+// submitter can wait on and collect the errors of. A job that needs to wait on
+// nested work submits it to a Child group, one depth further down, so that it
+// never waits on workers of its own depth, which could all be waiting too.
+type Group struct {
+	pool     *Pool
+	depth    int
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     func() bool
+	failFast bool
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// Group returns a group submitting at the first depth. Its jobs are cancelled
+// with ctx, or with the pool.
+func (p *Pool) Group(ctx context.Context) *Group {
+	return p.groupAt(ctx, 0)
+}
+
+// Inline returns a group of no pool, which runs every job in the goroutine
+// submitting it, for work that takes a group but has no pool to run on.
+func Inline(ctx context.Context) *Group {
+	return (*Pool)(nil).groupAt(ctx, 0)
+}
+
+func (p *Pool) groupAt(parent context.Context, depth int) *Group {
+	ctx, cancel := context.WithCancel(parent)
+	g := &Group{
+		pool:   p,
+		depth:  depth,
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   func() bool { return false },
+	}
+	if p != nil {
+		g.stop = context.AfterFunc(p.ctx, cancel)
+	}
+	return g
+}
+
+// Child returns a group for the nested work of this group's jobs, one depth
+// further down and cancelled along with this one.
+func (g *Group) Child() *Group {
+	return g.pool.groupAt(g.ctx, g.depth+1)
+}
+
+// Sibling returns a group at this group's depth, cancelled along with this
+// one, for part of its work that has to be waited on apart from the rest.
+func (g *Group) Sibling() *Group {
+	return g.pool.groupAt(g.ctx, g.depth)
+}
+
+// FailFast makes the first job to fail cancel the rest of the group
+func (g *Group) FailFast() *Group {
+	g.failFast = true
+	return g
+}
+
+// Context is the context the group's jobs are given
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// cancelled reports whether the group or its pool is cancelled. The pool is
+// checked directly since its cancellation reaches ctx asynchronously.
+func (g *Group) cancelled() bool {
+	return g.ctx.Err() != nil || (g.pool != nil && g.pool.ctx.Err() != nil)
+}
+
+func (g *Group) fail(err error) {
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.mu.Unlock()
+	if g.failFast {
+		g.cancel()
+	}
+}
+
+// Go submits job, blocking while every worker of the group's depth is busy.
+// A job submitted after the group is cancelled is skipped. Past the pool's
+// last depth, or once the pool is closed, there are no workers left to wait
+// for, so the job runs in the calling goroutine instead, which can never
+// deadlock.
+func (g *Group) Go(job Job) {
+	g.wg.Add(1)
+	run := func() {
+		defer g.wg.Done()
+		if g.cancelled() {
+			return
+		}
+		if err := job(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}
+	if g.cancelled() {
+		g.wg.Done()
+		return
+	}
+	if g.pool == nil || g.depth >= len(g.pool.jcs) {
+		run()
+		return
+	}
+	switch sent, closed := g.pool.send(g.depth, run, g.ctx.Done()); {
+	case closed:
+		run()
+	case !sent:
+		g.wg.Done()
+	}
+}
+
+// Background runs job in a goroutine of its own instead of on a worker, still
+// waited on and its error collected, for a job that only waits on other
+// groups and would hold a worker while it did.
+func (g *Group) Background(job Job) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.cancelled() {
+			return
+		}
+		if err := job(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// Wait waits for every job submitted so far and returns their errors joined,
+// nil if there were none. A group cancelled before all of its jobs ran
+// returns the cancellation as well, since some work was skipped. The group
+// cannot take more jobs afterwards.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	cancelled := g.cancelled()
+	g.stop()
+	g.cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	errs := append([]error{}, g.errs...)
+	if cancelled && len(errs) == 0 {
+		errs = append(errs, context.Cause(g.ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Errors returns the errors of the jobs that have failed so far
+func (g *Group) Errors() []error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]error{}, g.errs...)
+}
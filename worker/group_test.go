@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	pool := New(2, false)
+	pool.Run()
+	defer pool.Close()
+
+	var ran atomic.Int32
+	g := pool.Group(context.Background())
+	for i := 0; i < 5; i++ {
+		g.Go(func(ctx context.Context) error {
+			ran.Add(1)
+			if i%2 == 1 {
+				return errors.New("odd")
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	assert.Equal(t, int32(5), ran.Load())
+	assert.Error(t, err)
+	assert.Len(t, g.Errors(), 2)
+}
+
+// Every file waits on its chunks, and every chunk on its parts, with one
+// worker per depth and more depth than the pool has: nothing may deadlock.
+func TestGroupNested(t *testing.T) {
+	pool := NewWithDepth(1, 2, false)
+	pool.Run()
+	defer pool.Close()
+
+	var parts atomic.Int32
+	files := pool.Group(context.Background())
+	for i := 0; i < 3; i++ {
+		files.Go(func(ctx context.Context) error {
+			chunks := files.Child()
+			for j := 0; j < 3; j++ {
+				chunks.Go(func(ctx context.Context) error {
+					ps := chunks.Child()
+					for k := 0; k < 2; k++ {
+						ps.Go(func(ctx context.Context) error {
+							parts.Add(1)
+							return nil
+						})
+					}
+					return ps.Wait()
+				})
+			}
+			return chunks.Wait()
+		})
+	}
+	assert.NoError(t, files.Wait())
+	assert.Equal(t, int32(18), parts.Load())
+}
+
+func TestGroupCancel(t *testing.T) {
+	pool := New(1, false)
+	pool.Run()
+	defer pool.Close()
+
+	var ran atomic.Int32
+	g := pool.Group(context.Background()).FailFast()
+	g.Go(func(ctx context.Context) error { return errors.New("first") })
+	assert.Error(t, g.Wait())
+
+	g = pool.Group(context.Background())
+	pool.Cancel()
+	g.Go(func(ctx context.Context) error { ran.Add(1); return nil })
+	assert.ErrorIs(t, g.Wait(), context.Canceled)
+	assert.Equal(t, int32(0), ran.Load(), "a cancelled pool skips new jobs")
+}
+
+// A closed pool has no workers left, so late jobs run in the caller rather
+// than panicking on a closed channel.
+func TestGroupAfterClose(t *testing.T) {
+	pool := New(1, false)
+	pool.Run()
+	g := pool.Group(context.Background())
+	pool.Close()
+
+	var ran atomic.Int32
+	assert.NotPanics(t, func() {
+		pool.Add(func() { ran.Add(1) })
+		pool.AddWithDepth(1, func() { ran.Add(1) })
+	})
+	assert.Equal(t, int32(2), ran.Load())
+	g.Go(func(ctx context.Context) error { ran.Add(1); return nil })
+	assert.ErrorIs(t, g.Wait(), context.Canceled, "a closed pool is a cancelled one")
+}
+
+// Jobs still draining when Close is called can submit nested work.
+func TestCloseDrainsNested(t *testing.T) {
+	pool := NewWithDepth(1, 2, false)
+	pool.Run()
+	var parts atomic.Int32
+	release := make(chan struct{})
+	pool.Add(func() {
+		<-release
+		for i := 0; i < 3; i++ {
+			pool.AddWithDepth(1, func() { parts.Add(1) })
+		}
+	})
+	go close(release)
+	pool.Close()
+	assert.Equal(t, int32(3), parts.Load())
+}
+
+func TestInline(t *testing.T) {
+	g := Inline(context.Background()).FailFast()
+	var ran int
+	g.Go(func(ctx context.Context) error {
+		ran++
+		// Nested work of an inline group runs inline too.
+		child := g.Child()
+		child.Go(func(ctx context.Context) error {
+			ran++
+			return nil
+		})
+		return child.Wait()
+	})
+	assert.Equal(t, 2, ran)
+	g.Go(func(ctx context.Context) error { return errors.New("failed") })
+	g.Go(func(ctx context.Context) error {
+		ran++
+		return nil
+	})
+	assert.Equal(t, 2, ran)
+	assert.Error(t, g.Wait())
+}
+
+// A sibling's jobs are waited on by a background job of the group, which
+// holds no worker while it does: with one worker, anything else deadlocks.
+func TestSiblingBackground(t *testing.T) {
+	pool := New(1, false)
+	pool.Run()
+	defer pool.Close()
+
+	var ran atomic.Int32
+	g := pool.Group(context.Background())
+	tree := g.Sibling()
+	for i := 0; i < 3; i++ {
+		tree.Go(func(ctx context.Context) error {
+			ran.Add(1)
+			return nil
+		})
+	}
+	var cleaned atomic.Bool
+	g.Background(func(ctx context.Context) error {
+		err := tree.Wait()
+		cleaned.Store(ran.Load() == 3)
+		return err
+	})
+	assert.Nil(t, g.Wait())
+	assert.True(t, cleaned.Load())
+}
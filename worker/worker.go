@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -13,7 +14,13 @@ type Pool struct {
 	enableLog bool
 	size      int
 	jcs       []chan func()
-	wg        *sync.WaitGroup
+	gates     []*gate
+	wgs       []*sync.WaitGroup
+	mus       []*sync.RWMutex
+	closed    []bool
+	done      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 func (p *Pool) log(s string, vs ...any) {
@@ -22,43 +29,132 @@ func (p *Pool) log(s string, vs ...any) {
 	}
 }
 
+// Depth returns how many levels of nested work the pool has workers for
+func (p *Pool) Depth() int {
+	return len(p.jcs)
+}
+
+// Context is done once the pool is cancelled or closed
+func (p *Pool) Context() context.Context {
+	return p.ctx
+}
+
+// Cancel cancels every group's context: jobs not yet started are skipped and
+// running ones are asked to stop. Close still has to be called, and then
+// returns as soon as the running jobs have noticed.
+func (p *Pool) Cancel() {
+	p.cancel()
+}
+
 func (p *Pool) Add(job func()) {
 	p.AddWithDepth(0, job)
 }
 
+// AddWithDepth submits job to the workers of depth, blocking while they are
+// all busy. Once Close has stopped that depth there are no workers left to
+// take it, so the job runs in the calling goroutine instead.
 func (p *Pool) AddWithDepth(depth int, job func()) {
-	p.jcs[depth] <- job
+	if _, closed := p.send(depth, job, nil); closed {
+		job()
+		return
+	}
 	p.log("added job to pool")
 }
 
+// send hands job to the workers of depth, giving up once cancel is done; a
+// nil cancel never is. It holds the depth's read lock throughout, so Close
+// cannot close the channel under it. It reports whether a worker took the job, and whether
+// the depth was already closed, in which case nothing was sent.
+func (p *Pool) send(depth int, job func(), cancel <-chan struct{}) (sent, closed bool) {
+	p.mus[depth].RLock()
+	defer p.mus[depth].RUnlock()
+	if p.closed[depth] {
+		return false, true
+	}
+	select {
+	case p.jcs[depth] <- job:
+		return true, false
+	case <-cancel:
+		return false, false
+	}
+}
+
 // Run runs all jobs with worker pool
 func (p *Pool) Run() {
 	p.log("starting workers with %d workers", p.size)
 	for i := 0; i < p.size; i++ {
-		p.wg.Add(len(p.jcs))
-		for _, jc := range p.jcs {
-			go p.worker(i, jc, p.wg)
+		for depth, jc := range p.jcs {
+			p.wgs[depth].Add(1)
+			go p.worker(i, jc, p.gates[depth], p.wgs[depth])
 		}
 	}
 }
 
-// Close closes all workers
+// Close stops the pool taking jobs and drains it, waiting for every job
+// already submitted to finish. Depths are closed from the top down, each once
+// the one above has drained, so jobs still running can submit nested work.
 func (p *Pool) Close() {
-	for _, jc := range p.jcs {
+	for depth, jc := range p.jcs {
+		p.mus[depth].Lock()
+		p.closed[depth] = true
 		close(jc)
+		p.mus[depth].Unlock()
+		p.wgs[depth].Wait()
 	}
-	p.wg.Wait()
+	close(p.done)
+	p.cancel()
 	p.log("finished all the jobs")
 }
 
-func (p *Pool) worker(id int, jc <-chan func(), wg *sync.WaitGroup) {
+// SetLimit sets how many workers of each depth may run jobs at once, between
+// one and the size the pool was created with.
+func (p *Pool) SetLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n > p.size {
+		n = p.size
+	}
+	for _, g := range p.gates {
+		g.setLimit(n)
+	}
+	p.log("set concurrency limit to %d", n)
+}
+
+// Limit returns how many workers of each depth may run jobs at once
+func (p *Pool) Limit() int {
+	g := p.gates[0]
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// takeSaturated reports whether any depth had more work than its limit
+// allowed since the last call.
+func (p *Pool) takeSaturated() bool {
+	saturated := false
+	for _, g := range p.gates {
+		if g.takeSaturated() {
+			saturated = true
+		}
+	}
+	return saturated
+}
+
+func (p *Pool) worker(id int, jc <-chan func(), g *gate, wg *sync.WaitGroup) {
 	defer common.Recovery()
 	defer wg.Done()
 
 	start := time.Now()
 	p.log("started worker %d", id)
+	// The job is taken off the channel before waiting at the gate, so a
+	// submitter never blocks on a lowered limit, only the job does.
 	for job := range jc {
-		job()
+		g.acquire()
+		func() {
+			defer g.release()
+			job()
+		}()
 	}
 	p.log("stopped worker %d, with %s", id, time.Since(start))
 }
@@ -74,11 +170,19 @@ func NewWithDepth(size, depth int, enableLog bool) *Pool {
 	p := &Pool{
 		enableLog: enableLog,
 		size:      size,
-		wg:        new(sync.WaitGroup),
+		done:      make(chan struct{}),
 	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	p.jcs = make([]chan func(), depth)
+	p.gates = make([]*gate, depth)
+	p.wgs = make([]*sync.WaitGroup, depth)
+	p.mus = make([]*sync.RWMutex, depth)
+	p.closed = make([]bool, depth)
 	for index := range p.jcs {
 		p.jcs[index] = make(chan func())
+		p.gates[index] = newGate(size)
+		p.wgs[index] = new(sync.WaitGroup)
+		p.mus[index] = new(sync.RWMutex)
 	}
 	p.log("created pool with size %d", size)
 	return p